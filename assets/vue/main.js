@@ -141,6 +141,10 @@ var app = new Vue({
         request.artist = comp.artistName;
         request.token = token;
         request.lastFmUsername = comp.lastFMID;
+        // lets the server read listening times in the user's timezone
+        if (window.Intl !== undefined) {
+          request.timezone = Intl.DateTimeFormat().resolvedOptions().timeZone;
+        }
         request = JSON.stringify(request);
 
         // set a timer to trigger a message if the request is taking a while
@@ -149,31 +153,44 @@ var app = new Vue({
             "Your first playlist might take a while. Please be patient!";
         });
         timer.set({ time: 6000, autostart: true });
-        $.ajax({
-          url: "api/getPlaylist",
-          type: "POST",
-          dataType: "json",
-          data: request
-        })
-          .done(function(data) {
-            comp.songs = data;
+        var send = function() {
+          $.ajax({
+            url: "api/getPlaylist",
+            type: "POST",
+            dataType: "json",
+            data: request
           })
-          .fail(function(data) {
-            if (data.error !== undefined && data.error !== null) {
-              comp.error = data.error;
-            } else {
-              comp.error = data.responseText;
-              if (comp.error === undefined || comp.error.length === 0) {
-                comp.error =
-                  "An unknown error occurred in processing your request. Please try again later.";
+            .done(function(data) {
+              comp.songs = data.songs;
+              finish();
+            })
+            .fail(function(data) {
+              // the history is still being read, so ask again in a bit
+              if (data.status === 503) {
+                timer.stop();
+                comp.message =
+                  "Reading your listening history from Last.FM. This can take a few minutes the first time.";
+                setTimeout(send, 5000);
+                return;
               }
-            }
-          })
-          .always(function() {
-            timer.stop();
-            comp.activity = false;
-            comp.message = "";
-          });
+              if (data.error !== undefined && data.error !== null) {
+                comp.error = data.error;
+              } else {
+                comp.error = data.responseText;
+                if (comp.error === undefined || comp.error.length === 0) {
+                  comp.error =
+                    "An unknown error occurred in processing your request. Please try again later.";
+                }
+              }
+              finish();
+            });
+        };
+        var finish = function() {
+          timer.stop();
+          comp.activity = false;
+          comp.message = "";
+        };
+        send();
       } else {
         this.error =
           "You're currently not logged in to Spotify. Log in and try again.";
@@ -192,6 +209,7 @@ var app = new Vue({
         request.token = token;
         request.playlistName = "Generated by Spotkov";
         request.songs = this.songs;
+        request.lastFmUsername = comp.lastFMID;
         request = JSON.stringify(request);
         $.ajax({
           url: "api/createPlaylist",
@@ -0,0 +1,180 @@
+// Package canonical finds the identity of a track from the different ways
+// Last.FM reports it, so that "Song (Remastered 2011)", "Song - Live",
+// "Song feat. X" and "Song" are all the same track.
+// Only the identity is changed. Tracks should still be displayed the way
+// they were scrobbled.
+package canonical
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/snyderks/spotkov/tools"
+)
+
+// Rules control how tracks are canonicalized.
+// The zero value canonicalizes everything, and each field keeps
+// one kind of difference between tracks instead.
+type Rules struct {
+	// KeepVersions keeps versions of a track, such as "(Remastered 2011)",
+	// "- Live" or "[Radio Edit]", separate from the original.
+	KeepVersions bool `json:"keepVersions"`
+	// KeepFeatured keeps featured artists, such as "feat. X" or "(with X)",
+	// in titles and artists.
+	KeepFeatured bool `json:"keepFeatured"`
+	// KeepUnicode keeps accented letters, full-width characters and curly
+	// quotes and dashes from matching their plain counterparts.
+	KeepUnicode bool `json:"keepUnicode"`
+	// KeepArticles keeps "The Band" and "Band" as different artists.
+	KeepArticles bool `json:"keepArticles"`
+}
+
+// versionWords are the words that mark part of a title as the version.
+const versionWords = `remaster|remastered|live|version|edit|mono|stereo|demo|deluxe|bonus|anniversary|explicit|clean`
+
+// versionQualifiers are the words that can come before a version after a
+// dash, such as in "- Single Version" or "- Acoustic Live".
+const versionQualifiers = `single|album|radio|acoustic|original|extended|remix|studio|digital|mono|stereo|deluxe|anniversary`
+
+// versionEndings are the words that can come after a version after a dash,
+// such as in "- Live Version" or "- Deluxe Edition".
+const versionEndings = `version|edit|mix|edition|track`
+
+var (
+	// featuredBrackets matches a featured artist credit in brackets.
+	featuredBrackets = regexp.MustCompile(`(?i)\s*[(\[](?:feat\.?|ft\.?|featuring)\s[^)\]]*[)\]]`)
+	// withBrackets matches "(with X)", which is only a featured artist
+	// credit if X is a name (see uncredited).
+	withBrackets = regexp.MustCompile(`(?i)\s*[(\[]with\s+([^)\]\s]+)[^)\]]*[)\]]`)
+	// featuredTrailing matches a featured artist credit to the end of the string.
+	featuredTrailing = regexp.MustCompile(`(?i)\s+(?:feat\.?|ft\.?|featuring)\s.*$`)
+	// versionBrackets matches a version in brackets.
+	versionBrackets = regexp.MustCompile(`(?i)\s*[(\[][^)\]]*\b(?:` + versionWords + `)\b[^)\]]*[)\]]`)
+	// versionTrailing matches a version after a dash to the end of the title,
+	// such as "- Live", "- 2011 Remaster", "- Single Version" or
+	// "- Live at Wembley". The version has to come right after the dash,
+	// apart from a year or qualifiers, so "Part 1 - Live Wire" keeps its name.
+	versionTrailing = regexp.MustCompile(`(?i)\s+[-–—]\s+(?:\d{4}\s+)?(?:(?:` + versionQualifiers + `)\s+)*(?:` +
+		versionWords + `)(?:\s+\d{4})?(?:\s+(?:` + versionEndings + `))?(?:\s+(?:at|from|in|on)\s.*)?$`)
+	// leadingArticle and trailingArticle match "The" before an artist
+	// and after it, such as in "Beatles, The".
+	leadingArticle  = regexp.MustCompile(`(?i)^the\s+`)
+	trailingArticle = regexp.MustCompile(`(?i),\s*the$`)
+)
+
+// uncredited are the words that start "(with X)" when it's part of the title,
+// such as in "Stuck (With You)", rather than crediting an artist.
+var uncredited = map[string]bool{
+	"you": true, "me": true, "us": true, "him": true, "her": true, "them": true, "it": true,
+	"myself": true, "yourself": true, "himself": true, "herself": true, "ourselves": true, "themselves": true,
+	"my": true, "your": true, "our": true, "his": true, "their": true, "its": true,
+	"a": true, "an": true, "the": true, "this": true, "that": true, "or": true, "and": true,
+	"love": true, "everyone": true, "everybody": true, "someone": true, "somebody": true, "nobody": true,
+}
+
+// folds turns letters with accents and other marks into their plain forms,
+// and curly quotes and dashes into straight ones.
+var folds = strings.NewReplacer(
+	"à", "a", "á", "a", "â", "a", "ã", "a", "ä", "a", "å", "a", "ā", "a", "ă", "a", "ą", "a",
+	"æ", "ae", "ç", "c", "ć", "c", "ĉ", "c", "ċ", "c", "č", "c", "ď", "d", "đ", "d", "ð", "d",
+	"è", "e", "é", "e", "ê", "e", "ë", "e", "ē", "e", "ĕ", "e", "ė", "e", "ę", "e", "ě", "e",
+	"ĝ", "g", "ğ", "g", "ġ", "g", "ģ", "g", "ĥ", "h", "ħ", "h",
+	"ì", "i", "í", "i", "î", "i", "ï", "i", "ĩ", "i", "ī", "i", "ĭ", "i", "į", "i", "ı", "i",
+	"ĵ", "j", "ķ", "k", "ĺ", "l", "ļ", "l", "ľ", "l", "ŀ", "l", "ł", "l",
+	"ñ", "n", "ń", "n", "ņ", "n", "ň", "n",
+	"ò", "o", "ó", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o", "ō", "o", "ŏ", "o", "ő", "o", "œ", "oe",
+	"ŕ", "r", "ŗ", "r", "ř", "r", "ś", "s", "ŝ", "s", "ş", "s", "š", "s", "ß", "ss",
+	"ţ", "t", "ť", "t", "ŧ", "t", "þ", "th",
+	"ù", "u", "ú", "u", "û", "u", "ü", "u", "ũ", "u", "ū", "u", "ŭ", "u", "ů", "u", "ű", "u", "ų", "u",
+	"ŵ", "w", "ý", "y", "ÿ", "y", "ŷ", "y", "ź", "z", "ż", "z", "ž", "z",
+	"‘", "'", "’", "'", "‚", "'", "‛", "'", "“", `"`, "”", `"`, "„", `"`,
+	"‐", "-", "‑", "-", "‒", "-", "–", "-", "—", "-", "―", "-", " ", " ",
+)
+
+// Fold lowercases s and turns its accented letters, full-width characters
+// and curly quotes and dashes into plain ones.
+func Fold(s string) string {
+	s = strings.Map(func(r rune) rune {
+		// Full-width forms of ASCII characters
+		if r >= '！' && r <= '～' {
+			return r - '！' + '!'
+		}
+		if r == '　' {
+			return ' '
+		}
+		return r
+	}, strings.ToLower(s))
+	return folds.Replace(s)
+}
+
+// Title canonicalizes a track's title.
+// A title that would be left empty, such as "(Live)", is only trimmed.
+func (rules Rules) Title(title string) string {
+	s := title
+	if !rules.KeepUnicode {
+		s = Fold(s)
+	}
+	if !rules.KeepFeatured {
+		s = withoutFeatured(s)
+	}
+	if !rules.KeepVersions {
+		s = versionBrackets.ReplaceAllString(s, "")
+		s = versionTrailing.ReplaceAllString(s, "")
+	}
+	return orTrimmed(collapse(s), title)
+}
+
+// Artist canonicalizes a track's artist.
+// An artist that would be left empty, such as "The", is only trimmed.
+func (rules Rules) Artist(artist string) string {
+	s := artist
+	if !rules.KeepUnicode {
+		s = Fold(s)
+	}
+	if !rules.KeepFeatured {
+		s = withoutFeatured(s)
+	}
+	if !rules.KeepArticles {
+		s = trailingArticle.ReplaceAllString(collapse(s), "")
+		s = leadingArticle.ReplaceAllString(s, "")
+	}
+	return orTrimmed(collapse(s), artist)
+}
+
+// withoutFeatured removes featured artist credits from s.
+func withoutFeatured(s string) string {
+	s = featuredBrackets.ReplaceAllString(s, "")
+	s = withBrackets.ReplaceAllStringFunc(s, func(credit string) string {
+		first := withBrackets.FindStringSubmatch(credit)[1]
+		if uncredited[strings.Trim(strings.ToLower(first), `'".,!?`)] {
+			return credit
+		}
+		return ""
+	})
+	return featuredTrailing.ReplaceAllString(s, "")
+}
+
+// Key is what a canonicalized title or artist is compared by, ignoring case,
+// punctuation and spacing, so the different ways Last.FM reports it match.
+// Something with only punctuation, such as the band "!!!", is compared by
+// all of it instead of as nothing.
+func Key(s string) string {
+	key := collapse(tools.LowerAndStripNonAlphaNumeric(s))
+	if len(key) == 0 {
+		return collapse(strings.ToLower(s))
+	}
+	return key
+}
+
+// collapse trims s and turns every run of whitespace in it into one space.
+func collapse(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// orTrimmed returns s, or original trimmed if s is empty.
+func orTrimmed(s string, original string) string {
+	if len(s) == 0 {
+		return collapse(original)
+	}
+	return s
+}
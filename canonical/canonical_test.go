@@ -0,0 +1,82 @@
+package canonical
+
+import "testing"
+
+func TestTitle(t *testing.T) {
+	tests := []struct {
+		rules Rules
+		title string
+		want  string
+	}{
+		{Rules{}, "Song (Remastered 2011)", "song"},
+		{Rules{}, "Song [Radio Edit]", "song"},
+		{Rules{}, "Song - Live", "song"},
+		{Rules{}, "Song - 2011 Remaster", "song"},
+		{Rules{}, "Song - Remastered 2011", "song"},
+		{Rules{}, "Song - Single Version", "song"},
+		{Rules{}, "Song - Live at Wembley", "song"},
+		{Rules{}, "Song - Deluxe Edition", "song"},
+		{Rules{}, "Song feat. Someone", "song"},
+		{Rules{}, "Song (feat. Someone)", "song"},
+		{Rules{}, "Song (with Lana Del Rey)", "song"},
+		{Rules{}, "Sóng", "song"},
+		// A dash followed by something other than a version is part of the name.
+		{Rules{}, "Part 1 - Live Wire", "part 1 - live wire"},
+		{Rules{}, "Intro - Live Forever", "intro - live forever"},
+		// "(with X)" is part of the title when X isn't a name.
+		{Rules{}, "Stuck (With You)", "stuck (with you)"},
+		{Rules{}, "Dancing (with Myself)", "dancing (with myself)"},
+		// Nothing is left empty.
+		{Rules{}, "(Live)", "(Live)"},
+		{Rules{KeepVersions: true}, "Song - Live", "song - live"},
+		{Rules{KeepFeatured: true}, "Song (feat. Someone)", "song (feat. someone)"},
+		{Rules{KeepUnicode: true}, "Sóng", "Sóng"},
+	}
+	for _, test := range tests {
+		if got := test.rules.Title(test.title); got != test.want {
+			t.Errorf("%+v.Title(%q) = %q, want %q", test.rules, test.title, got, test.want)
+		}
+	}
+}
+
+func TestArtist(t *testing.T) {
+	tests := []struct {
+		rules  Rules
+		artist string
+		want   string
+	}{
+		{Rules{}, "The Beatles", "beatles"},
+		{Rules{}, "Beatles, The", "beatles"},
+		{Rules{}, "Artist feat. Someone", "artist"},
+		{Rules{}, "Kanye West & Jay-Z", "kanye west & jay-z"},
+		{Rules{}, "!!!", "!!!"},
+		{Rules{}, "The", "the"},
+		{Rules{KeepArticles: true}, "The Beatles", "the beatles"},
+		{Rules{KeepFeatured: true}, "Artist feat. Someone", "artist feat. someone"},
+	}
+	for _, test := range tests {
+		if got := test.rules.Artist(test.artist); got != test.want {
+			t.Errorf("%+v.Artist(%q) = %q, want %q", test.rules, test.artist, got, test.want)
+		}
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"Song", "song"},
+		{"Don't Stop", "dont stop"},
+		{"kanye west & jay-z", "kanye west jay z"},
+		{"  spaced   out  ", "spaced out"},
+		// Only punctuation is kept rather than left empty.
+		{"!!!", "!!!"},
+		{"...", "..."},
+	}
+	for _, test := range tests {
+		if got := Key(test.s); got != test.want {
+			t.Errorf("Key(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
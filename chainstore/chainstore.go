@@ -0,0 +1,256 @@
+// Package chainstore keeps built Markov chains in Redis, one hash per prefix,
+// so a chain can be loaded without the history it was built from and only
+// the prefixes a playlist uses are fetched.
+package chainstore
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/snyderks/spotkov-web/markov"
+	"github.com/snyderks/spotkov-web/redisConn"
+)
+
+// keyPrefix starts every Redis key a chain is stored under. It changes when
+// the way songs are keyed does, so chains stored before are built again.
+const keyPrefix = "chain2."
+
+// idsPrefix starts the Redis key of the set of ids of the chains stored
+// for each user.
+const idsPrefix = "chainIDs."
+
+// atField is the field in a prefix's hash holding the newest song in the
+// chain when it was stored. Every other field is a suffix, by its position.
+const atField = "at"
+
+// expiration is how long a chain is kept after it was built. The whole chain
+// expires at once, so it's built again instead of being loaded without some
+// of its prefixes.
+const expiration = 30 * 24 * time.Hour
+
+// expirationSlack is how much longer than its header each prefix is kept,
+// so a prefix can't expire while the chain can still be loaded.
+const expirationSlack = time.Hour
+
+// batchSize is how many prefixes are saved in one round trip.
+const batchSize = 1000
+
+// ErrNotStored is returned by Load when there isn't a chain stored under the id,
+// and by SaveNodes when the chain it would add to has expired.
+var ErrNotStored = errors.New("The chain isn't stored.")
+
+var c *redis.Client
+
+// available is false if Redis couldn't be reached at startup.
+var available bool
+
+func init() {
+	var err error
+	c, err = redisConn.Connect()
+	available = err == nil
+	if err != nil {
+		log.Println("Chains won't be stored:", err.Error())
+	}
+}
+
+// Store fetches the prefixes of a stored chain from Redis.
+type Store struct {
+	id string
+}
+
+// Node fetches the suffixes of the prefix with the given key (see markov.NodeStore).
+func (s Store) Node(key string) (markov.Suffixes, time.Time, bool, error) {
+	fields, err := c.HGetAll(nodeKey(s.id, key)).Result()
+	if err != nil {
+		return markov.Suffixes{}, time.Time{}, false, err
+	}
+	if len(fields) == 0 {
+		return markov.Suffixes{}, time.Time{}, false, nil
+	}
+	at, err := time.Parse(time.RFC3339Nano, fields[atField])
+	if err != nil {
+		return markov.Suffixes{}, time.Time{}, false, err
+	}
+	positions := make([]int, 0, len(fields)-1)
+	for field := range fields {
+		if field == atField {
+			continue
+		}
+		position, err := strconv.Atoi(field)
+		if err != nil {
+			return markov.Suffixes{}, time.Time{}, false, err
+		}
+		positions = append(positions, position)
+	}
+	// Suffixes are kept in the order they were added so the same
+	// seed picks the same songs as the chain that was stored.
+	sort.Ints(positions)
+	suffixes := markov.Suffixes{Suffixes: make([]markov.Suffix, len(positions))}
+	for i, position := range positions {
+		err = json.Unmarshal([]byte(fields[strconv.Itoa(position)]), &suffixes.Suffixes[i])
+		if err != nil {
+			return markov.Suffixes{}, time.Time{}, false, err
+		}
+	}
+	return suffixes, at, true, nil
+}
+
+// Load creates the chain stored under the id, which fetches its prefixes
+// from Redis as they're used, along with when it expires. The chain can't be
+// used after that, since its prefixes are gone.
+// Returns ErrNotStored if there isn't one.
+func Load(id string) (markov.Chain, time.Time, error) {
+	if !available {
+		return markov.Chain{}, time.Time{}, ErrNotStored
+	}
+	var get *redis.StringCmd
+	var ttl *redis.DurationCmd
+	_, err := c.Pipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(headerKey(id))
+		ttl = pipe.PTTL(headerKey(id))
+		return nil
+	})
+	if err == redis.Nil || (err == nil && ttl.Val() <= 0) {
+		return markov.Chain{}, time.Time{}, ErrNotStored
+	}
+	if err != nil {
+		return markov.Chain{}, time.Time{}, err
+	}
+	header := markov.Header{}
+	err = json.Unmarshal([]byte(get.Val()), &header)
+	if err != nil {
+		return markov.Chain{}, time.Time{}, err
+	}
+	// A chain stored before its stationary distribution was is built again
+	// rather than fetching every prefix to work it out.
+	if header.Central == nil {
+		return markov.Chain{}, time.Time{}, ErrNotStored
+	}
+	chain, err := markov.LoadChain(header, Store{id: id})
+	return chain, time.Now().Add(ttl.Val()), err
+}
+
+// Save stores the whole chain built from a user's history under the id,
+// replacing any chain stored there, and returns when it expires.
+func Save(userID string, id string, chain markov.Chain) (time.Time, error) {
+	if !available {
+		return time.Time{}, errors.New("Redis isn't available.")
+	}
+	keys := make([]string, 0, len(chain.Prefixes))
+	for key := range chain.Prefixes {
+		keys = append(keys, key)
+	}
+	expires := time.Now().Add(expiration)
+	err := save(id, chain, keys, expiration)
+	if err != nil {
+		return time.Time{}, err
+	}
+	_, err = c.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.SAdd(idsPrefix+userID, id)
+		pipe.Expire(idsPrefix+userID, expiration)
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return expires, nil
+}
+
+// Stored returns the ids of the chains stored for a user that haven't expired.
+func Stored(userID string) ([]string, error) {
+	if !available {
+		return nil, errors.New("Redis isn't available.")
+	}
+	ids, err := c.SMembers(idsPrefix + userID).Result()
+	if err != nil {
+		return nil, err
+	}
+	stored := ids[:0]
+	for _, id := range ids {
+		exists, err := c.Exists(headerKey(id)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if exists == 0 {
+			c.SRem(idsPrefix+userID, id)
+			continue
+		}
+		stored = append(stored, id)
+	}
+	return stored, nil
+}
+
+// SaveNodes stores the chain's header and the prefixes with the given keys
+// under the id, such as the ones changed by extending it. They expire along
+// with the rest of the stored chain, so extending a chain doesn't keep it
+// any longer. Returns ErrNotStored if the stored chain has already expired,
+// since the rest of its prefixes might be gone.
+func SaveNodes(id string, chain markov.Chain, keys []string) error {
+	if !available {
+		return errors.New("Redis isn't available.")
+	}
+	ttl, err := c.PTTL(headerKey(id)).Result()
+	if err != nil {
+		return err
+	}
+	// A key that doesn't exist or doesn't expire has a negative TTL.
+	if ttl <= 0 {
+		return ErrNotStored
+	}
+	return save(id, chain, keys, ttl)
+}
+
+// save stores the chain's header and the prefixes with the given keys under
+// the id, with the header expiring after ttl.
+func save(id string, chain markov.Chain, keys []string, ttl time.Duration) error {
+	header := chain.Header()
+	b, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	at := header.Newest.Format(time.RFC3339Nano)
+	for start := 0; start < len(keys); start += batchSize {
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		_, err = c.Pipelined(func(pipe redis.Pipeliner) error {
+			for _, key := range keys[start:end] {
+				fields := map[string]interface{}{atField: at}
+				for i, suffix := range chain.Prefixes[key].Suffixes {
+					s, err := json.Marshal(suffix)
+					if err != nil {
+						return err
+					}
+					fields[strconv.Itoa(i)] = s
+				}
+				// Suffixes stored before that aren't in the chain anymore
+				// would be read back with the rest.
+				pipe.Del(nodeKey(id, key))
+				pipe.HMSet(nodeKey(id, key), fields)
+				pipe.Expire(nodeKey(id, key), ttl+expirationSlack)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// The header goes last so the chain isn't loaded before its prefixes are there.
+	return c.Set(headerKey(id), b, ttl).Err()
+}
+
+// headerKey is the Redis key a chain's header is stored under.
+func headerKey(id string) string {
+	return keyPrefix + id
+}
+
+// nodeKey is the Redis key a chain's prefix is stored under.
+func nodeKey(id string, key string) string {
+	return keyPrefix + id + "." + key
+}
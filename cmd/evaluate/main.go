@@ -0,0 +1,95 @@
+// Command evaluate measures how well a chain built with the given settings
+// predicts the most recent part of a user's cached listening history.
+//
+// It splits the history cached in Redis by time, builds a chain from the older
+// part, and reports the held-out log likelihood, perplexity, hit rates and
+// coverage for the recent part. Run it from the directory with config.json so
+// it uses the same Redis as the server.
+//
+//	evaluate -user someone -holdout 30 -order 2 -gap 30
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/snyderks/spotkov-web/history"
+	"github.com/snyderks/spotkov-web/markov"
+)
+
+func main() {
+	user := flag.String("user", "", "Last.FM username to read the cached history of")
+	holdout := flag.Float64("holdout", 30, "days at the end of the history to evaluate with")
+	order := flag.Int("order", 1, "number of previous songs to predict from")
+	gap := flag.Int("gap", 60, "longest gap in minutes between songs in one session")
+	skip := flag.Int("skip", 0, "songs followed by another within this many seconds count as skipped")
+	penalize := flag.Bool("penalize", false, "count transitions to skipped songs against them")
+	halfLife := flag.Float64("halflife", 0, "days for a transition's weight to halve, or 0 for no decay")
+	ks := flag.String("k", "1,5,10", "comma separated numbers of top predictions to work out hit rates for")
+	flag.Parse()
+	if len(*user) == 0 {
+		log.Fatal("A username is required.")
+	}
+	topK, err := parseKs(*ks)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	songs, err := history.Cached(*user)
+	if err != nil {
+		log.Fatal("Couldn't read the cached history: ", err)
+	}
+
+	var newest time.Time
+	for _, song := range songs {
+		if song.Timestamp.After(newest) {
+			newest = song.Timestamp
+		}
+	}
+	cutoff := newest.Add(-time.Duration(*holdout * float64(24*time.Hour)))
+	train, heldOut := markov.SplitHistory(songs, cutoff)
+	settings := markov.Settings{
+		Order:         *order,
+		SessionGap:    time.Duration(*gap) * time.Minute,
+		SkipGap:       time.Duration(*skip) * time.Second,
+		PenalizeSkips: *penalize,
+		HalfLife:      time.Duration(*halfLife * float64(24*time.Hour)),
+	}
+
+	start := time.Now()
+	chain := markov.BuildChain(train, settings)
+	built := time.Since(start)
+	start = time.Now()
+	eval := markov.EvaluateChain(chain, heldOut, settings, topK)
+	evaluated := time.Since(start)
+
+	fmt.Println("Training songs:", len(train), "Held-out songs:", len(heldOut), "from", cutoff.Format(time.RFC3339))
+	fmt.Println("Held-out transitions:", eval.Transitions)
+	fmt.Printf("Log likelihood: %.2f\n", eval.LogLikelihood)
+	fmt.Printf("Perplexity: %.2f\n", eval.Perplexity)
+	for _, k := range topK {
+		fmt.Printf("Hit@%d: %.4f\n", k, eval.HitRate[k])
+	}
+	fmt.Printf("Coverage: %.4f\n", eval.Coverage)
+	fmt.Println("Built the chain in", built, "and evaluated it in", evaluated)
+}
+
+// parseKs reads a comma separated list of numbers above zero, smallest first.
+func parseKs(s string) ([]int, error) {
+	var ks []int
+	for _, part := range strings.Split(s, ",") {
+		k, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || k < 1 {
+			return nil, errors.New("\"" + part + "\" isn't a number above zero.")
+		}
+		ks = append(ks, k)
+	}
+	sort.Ints(ks)
+	return ks, nil
+}
@@ -0,0 +1,126 @@
+// Command spotkov-sync builds what the web server needs from users' whole
+// listening histories, so the web server never has to read one itself.
+//
+// It waits for the work the web server queues in Redis (see syncqueue), reads
+// each user's whole history from Last.FM once for everything queued for them,
+// and stores the chains and similar songs found from it in Redis for the web
+// server to load. Reading a history again only asks Last.FM for the songs
+// played since the last time, which are added to the chains already stored.
+// Run it from the directory with config.json so it uses the same Redis and
+// Last.FM key as the server.
+//
+//	spotkov-sync -workers 2
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"time"
+
+	"github.com/snyderks/spotkov-web/chainstore"
+	"github.com/snyderks/spotkov-web/history"
+	"github.com/snyderks/spotkov-web/markov"
+	"github.com/snyderks/spotkov-web/similarstore"
+	"github.com/snyderks/spotkov-web/syncqueue"
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// retryDelay is how long to wait before reading the queue again after
+// it couldn't be read.
+const retryDelay = 10 * time.Second
+
+func main() {
+	workers := flag.Int("workers", 2, "most users to read the whole history of at once")
+	flag.Parse()
+	if *workers < 1 {
+		log.Fatal("At least one worker is required.")
+	}
+	for i := 1; i < *workers; i++ {
+		go work()
+	}
+	work()
+}
+
+// work syncs users as they're queued.
+func work() {
+	for {
+		userID, jobs, err := syncqueue.Next()
+		if err != nil {
+			log.Println("Couldn't read the queue:", err.Error())
+			time.Sleep(retryDelay)
+			continue
+		}
+		songs, err := history.Read(userID)
+		if err != nil {
+			log.Println("Couldn't sync "+userID+":", err.Error())
+		}
+		for _, job := range jobs {
+			jobErr := err
+			if err == nil {
+				jobErr = run(job, songs)
+			}
+			syncqueue.Done(job, jobErr)
+		}
+		syncqueue.Finish(userID)
+	}
+}
+
+// run does a job with the user's whole history, oldest first.
+func run(job syncqueue.Job, songs []lastFm.Song) error {
+	switch job.Kind {
+	case syncqueue.BuildChain:
+		_, err := chainstore.Save(job.UserID, job.ID, markov.BuildChain(songs, job.Settings))
+		if err != nil {
+			log.Println("Couldn't store the chain:", err.Error())
+		}
+		return err
+	case syncqueue.IndexSimilar:
+		embeddings := markov.TrainEmbeddings(songs, job.Settings, markov.EmbeddingSettings{})
+		index := markov.IndexSimilarSongs(similarstore.MaxSimilar, songs, job.Settings, &embeddings)
+		info := similarstore.Info{
+			Indexed:    time.Now(),
+			SessionGap: embeddings.SessionGap,
+			Canonical:  embeddings.Canonical,
+		}
+		err := similarstore.Save(job.UserID, info, index)
+		if err != nil {
+			log.Println("Couldn't store the similar songs:", err.Error())
+		}
+		return err
+	case syncqueue.Refresh:
+		return refresh(job.UserID, songs)
+	}
+	return errors.New("There's no such job as " + job.Kind + ".")
+}
+
+// refresh adds the songs played since each of the user's stored chains was
+// built to it, storing only the prefixes that changed. Chains keep the
+// central songs they were built with until they're built again.
+func refresh(userID string, songs []lastFm.Song) error {
+	ids, err := chainstore.Stored(userID)
+	if err != nil {
+		log.Println("Couldn't find the stored chains:", err.Error())
+		return err
+	}
+	for _, id := range ids {
+		chain, _, err := chainstore.Load(id)
+		if err == chainstore.ErrNotStored {
+			continue
+		}
+		if err != nil {
+			log.Println("Couldn't load the stored chain:", err.Error())
+			return err
+		}
+		changed := chain.Extend(songs)
+		if len(changed) == 0 {
+			continue
+		}
+		err = chainstore.SaveNodes(id, chain, changed)
+		if err != nil && err != chainstore.ErrNotStored {
+			log.Println("Couldn't store the chain:", err.Error())
+			return err
+		}
+	}
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 
 	"strings"
 
+	"github.com/snyderks/spotkov-web/canonical"
 	"github.com/snyderks/spotkov/lastFm"
 	"github.com/xrash/smetrics"
 )
@@ -35,21 +36,34 @@ type matchResponse struct {
 	Matches []lastFm.BaseSong `json:"matches"`
 }
 
-func bestMatches(s string, c map[lastFm.BaseSong]bool, matches int, useTitles bool) matchResponse {
-	var d distances
+// bestMatches finds the songs whose titles (or artists) are closest to s.
+// Songs that are the same track under rules only show up once, the way
+// that's closest to s, and so do artists when matching artists.
+func bestMatches(s string, c map[lastFm.BaseSong]bool, matches int, useTitles bool, rules canonical.Rules) matchResponse {
+	folded := canonical.Fold(s)
+	closest := make(map[string]distance)
 	for key := range c {
 		if len(key.Title) > 0 {
 			var cmp string
+			identity := rules.Artist(key.Artist)
 			if useTitles {
 				cmp = key.Title
+				identity += "\x1e" + rules.Title(key.Title)
 			} else {
 				cmp = key.Artist
 			}
-			if strings.Contains(cmp, s) {
-				d = append(d, distance{Key: key, Amount: smetrics.WagnerFischer(s, cmp, 1, 1, 2)})
+			if strings.Contains(cmp, s) || (!rules.KeepUnicode && strings.Contains(canonical.Fold(cmp), folded)) {
+				match := distance{Key: key, Amount: smetrics.WagnerFischer(s, cmp, 1, 1, 2)}
+				if other, exists := closest[identity]; !exists || closer(match, other) {
+					closest[identity] = match
+				}
 			}
 		}
 	}
+	d := make(distances, 0, len(closest))
+	for _, match := range closest {
+		d = append(d, match)
+	}
 	sort.Sort(d)
 	var ret []lastFm.BaseSong
 	for i, el := range d {
@@ -61,6 +75,18 @@ func bestMatches(s string, c map[lastFm.BaseSong]bool, matches int, useTitles bo
 	return matchResponse{len(ret), ret}
 }
 
+// closer reports whether a is a better match than b, breaking ties by
+// artist and title so the same one is always picked.
+func closer(a distance, b distance) bool {
+	if a.Amount != b.Amount {
+		return a.Amount < b.Amount
+	}
+	if a.Key.Artist != b.Key.Artist {
+		return a.Key.Artist < b.Key.Artist
+	}
+	return a.Key.Title < b.Key.Title
+}
+
 func autocomplete(req matchRequest, useTitles bool) (matchResponse, error) {
 	songs := lastFm.SongMap{}
 	songs.Songs = make(map[lastFm.BaseSong]bool)
@@ -68,7 +94,7 @@ func autocomplete(req matchRequest, useTitles bool) (matchResponse, error) {
 	if err != nil {
 		return matchResponse{}, err
 	}
-	matches := bestMatches(req.S, songs.Songs, 10, useTitles)
+	matches := bestMatches(req.S, songs.Songs, 10, useTitles, userCanonicalRules(req.UserID))
 	return matches, nil
 }
 
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/snyderks/spotkov-web/markov"
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// bridgeRequest is the expected format for a client request to generate
+// a playlist that goes from one song to another.
+type bridgeRequest struct {
+	Length         string `json:"length"`
+	StartTitle     string `json:"startTitle"`
+	StartArtist    string `json:"startArtist"`
+	EndTitle       string `json:"endTitle"`
+	EndArtist      string `json:"endArtist"`
+	LastFmUsername string `json:"lastFmUsername"`
+}
+
+// bridgeResponse is returned with a generated bridge playlist, along with
+// the log probability of the transitions taken.
+type bridgeResponse struct {
+	LogProbability float64       `json:"logProbability"`
+	Songs          []lastFm.Song `json:"songs"`
+}
+
+func createBridgePlaylist(w http.ResponseWriter, r *http.Request) {
+	// Only POST, same as createLastFmPlaylist.
+	if r.Method != "POST" {
+		w.WriteHeader(403)
+		return
+	}
+	maxBytes := 4000 // NOTHING should be sending 4KB requests to this.
+	if r.ContentLength > int64(maxBytes) {
+		return
+	}
+	var requestBody []byte
+	requestBody, err := ioutil.ReadAll(r.Body)
+	err = r.Body.Close()
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	req := bridgeRequest{}
+	err = json.Unmarshal(requestBody, &req)
+	if err != nil {
+		w.WriteHeader(400)
+		e, err := json.Marshal(friendlyError{"Reading the playlist request failed."})
+		if err == nil {
+			w.Write(e)
+		}
+		return
+	}
+	length, err := parseLength(req.Length)
+	if err != nil {
+		w.WriteHeader(400)
+		e, err := json.Marshal(friendlyError{"The length of the playlist wasn't a number."})
+		if err == nil {
+			w.Write(e)
+		}
+		return
+	}
+	chain, err := chains.get(req.LastFmUsername, markov.Settings{
+		Canonical: userCanonicalRules(req.LastFmUsername),
+	})
+	if err != nil {
+		writeChainError(w, err)
+		return
+	}
+	list, logProb, err := markov.Bridge(length,
+		lastFm.Song{Title: req.StartTitle, Artist: req.StartArtist},
+		lastFm.Song{Title: req.EndTitle, Artist: req.EndArtist},
+		chain)
+	if err != nil {
+		// These are all problems with the songs picked, so let the user know.
+		w.WriteHeader(400)
+		e, err := json.Marshal(friendlyError{err.Error()})
+		if err == nil {
+			w.Write(e)
+		}
+		return
+	}
+	listJSON, err := json.Marshal(bridgeResponse{LogProbability: logProb, Songs: list})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Write(listJSON)
+}
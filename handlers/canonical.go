@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/snyderks/spotkov-web/canonical"
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// canonicalPrefix is the Redis key prefix for a user's canonicalization rules.
+const canonicalPrefix = "canonical."
+
+// userCanonicalRules finds the saved rules for which of a user's tracks are
+// the same track. Without any, everything is canonicalized.
+func userCanonicalRules(userID string) canonical.Rules {
+	saved := canonical.Rules{}
+	err := lastFm.ReadCache(userID, canonicalPrefix, &saved)
+	if err != nil {
+		return canonical.Rules{}
+	}
+	return saved
+}
+
+// requestCanonicalRules finds the rules for which of the user's tracks are
+// the same track for a playlist request. Rules passed in the request are
+// saved as the user's setting if the request is from the Spotify user who
+// owns their settings (see ownsSettings). Otherwise the saved setting is used.
+func requestCanonicalRules(req playlistRequest) canonical.Rules {
+	saved := userCanonicalRules(req.LastFmUsername)
+	if req.Canonical == nil || *req.Canonical == saved || !ownsSettings(req.LastFmUsername, req.Token) {
+		return saved
+	}
+	err := lastFm.WriteCache(req.LastFmUsername, canonicalPrefix, *req.Canonical)
+	if err != nil {
+		log.Println("Couldn't save the canonicalization rules:", err.Error())
+	}
+	return *req.Canonical
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/snyderks/spotkov-web/markov"
+)
+
+// defaultCentralSongs and maxCentralSongs are how many songs are returned
+// for a request for the most central songs if it doesn't say, and at most.
+const (
+	defaultCentralSongs = 20
+	maxCentralSongs     = 100
+)
+
+// centralRequest is the expected format for a client request for the
+// most central songs in a user's history.
+type centralRequest struct {
+	LastFmUsername string `json:"lastFmUsername"`
+	// K is how many songs to return, from 1 to maxCentralSongs.
+	K int `json:"k"`
+}
+
+// centralResponse is returned with the most central songs, most central first.
+type centralResponse struct {
+	Songs []markov.Centrality `json:"songs"`
+}
+
+func centralSongsHandler(w http.ResponseWriter, r *http.Request) {
+	maxBytes := 4000 // NOTHING should be sending 4KB requests to this.
+	if r.ContentLength > int64(maxBytes) {
+		return
+	}
+	var requestBody []byte
+	requestBody, err := ioutil.ReadAll(r.Body)
+	err = r.Body.Close()
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	req := centralRequest{}
+	err = json.Unmarshal(requestBody, &req)
+	if err != nil {
+		w.WriteHeader(400)
+		e, err := json.Marshal(friendlyError{"Reading the request failed."})
+		if err == nil {
+			w.Write(e)
+		}
+		return
+	}
+	k := req.K
+	if k <= 0 {
+		k = defaultCentralSongs
+	} else if k > maxCentralSongs {
+		k = maxCentralSongs
+	}
+	chain, err := chains.get(req.LastFmUsername, markov.Settings{
+		Canonical: userCanonicalRules(req.LastFmUsername),
+	})
+	if err != nil {
+		writeChainError(w, err)
+		return
+	}
+	songs := chain.StationaryDistribution()
+	if len(songs) > k {
+		songs = songs[:k]
+	}
+	resp, err := json.Marshal(centralResponse{Songs: songs})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Write(resp)
+}
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snyderks/spotkov-web/chainstore"
+	"github.com/snyderks/spotkov-web/markov"
+	"github.com/snyderks/spotkov-web/syncqueue"
+)
+
+// maxCachedChains is the most chains kept in memory at once. The least
+// recently used chain is dropped to make room for a new one.
+const maxCachedChains = 32
+
+// chains holds the chains built for recent requests.
+var chains = newChainCache(maxCachedChains)
+
+// day is the length of a day, for the half-lives chains are built with.
+const day = 24 * time.Hour
+
+// Chains are only built with these half-lives, session gaps and skip gaps,
+// so requests share chains instead of each building their own. Requests for
+// anything else are turned away (see checkChoice).
+var (
+	halfLives   = []time.Duration{7 * day, 30 * day, 90 * day, 365 * day}
+	sessionGaps = []time.Duration{15 * time.Minute, 30 * time.Minute, time.Hour, 2 * time.Hour, 4 * time.Hour}
+	skipGaps    = []time.Duration{15 * time.Second, 30 * time.Second, time.Minute}
+)
+
+// refreshInterval is how long a loaded chain is used before it's loaded again
+// with the songs scrobbled since, and how often those songs are added to a
+// user's stored chains by the sync command.
+const refreshInterval = 5 * time.Minute
+
+// chainCache keeps the chains loaded for each user and settings, so a request
+// doesn't have to load the chain again. Chains are built from the whole
+// history by the sync command and stored in Redis (see syncqueue and
+// chainstore), which also adds the songs played since to them, so the web
+// server never reads a history and a chain is only built once across
+// restarts and servers.
+type chainCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List               // cachedChains, most recently used first
+	entries map[string]*list.Element // elements in order by cache key
+}
+
+// cachedChain is a chain in the cache. Its lock is only held while the chain
+// is swapped for one loaded again, since chains aren't changed once loaded.
+type cachedChain struct {
+	key      string
+	userID   string
+	settings markov.Settings
+	mu       sync.Mutex
+	built    bool
+	chain    markov.Chain
+	// loaded is when the chain was loaded from Redis, and expires is when
+	// the stored chain expires. It's loaded again after either
+	// refreshInterval or expires, or built again if it isn't stored anymore.
+	loaded  time.Time
+	expires time.Time
+}
+
+func newChainCache(size int) *chainCache {
+	return &chainCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get finds the chain for a user's history built with the given settings,
+// rounded to ones chains are built with (see boundSettings), loading it from
+// Redis if it isn't cached or it's been loaded for longer than
+// refreshInterval. Returns errSyncing if the chain has to be built from their
+// whole history, which is queued for the sync command.
+func (c *chainCache) get(userID string, settings markov.Settings) (markov.Chain, error) {
+	settings = boundSettings(settings)
+	entry := c.entry(userID, settings)
+	entry.mu.Lock()
+	chain, loaded := entry.chain, entry.loaded
+	built := entry.built && time.Now().Before(entry.expires)
+	entry.mu.Unlock()
+	if built && time.Since(loaded) < refreshInterval {
+		return chain, nil
+	}
+	loadedChain, err := entry.load()
+	if err != nil && err != errSyncing && built {
+		// The chain is still worth using without the newest songs.
+		log.Println("Couldn't load the stored chain again:", err.Error())
+		return chain, nil
+	}
+	return loadedChain, err
+}
+
+// load loads the chain from Redis and caches it, and queues the songs
+// scrobbled since to be added to the user's stored chains by the sync
+// command, at most once every refreshInterval.
+// If it isn't stored it's queued to be built from the user's whole history,
+// returning errSyncing, or why the last build failed once if it did.
+func (entry *cachedChain) load() (markov.Chain, error) {
+	chain, expires, err := chainstore.Load(entry.key)
+	if err == chainstore.ErrNotStored {
+		job := entry.job()
+		if err := syncqueue.Failed(job); err != nil {
+			// Let the user know once, then try again on the next request.
+			return markov.Chain{}, err
+		}
+		err = syncqueue.Add(job)
+		if err != nil {
+			return markov.Chain{}, err
+		}
+		return markov.Chain{}, errSyncing
+	}
+	if err != nil {
+		return markov.Chain{}, err
+	}
+	entry.mu.Lock()
+	entry.chain = chain
+	entry.built = true
+	entry.loaded = time.Now()
+	entry.expires = expires
+	entry.mu.Unlock()
+	refresh := syncqueue.Job{Kind: syncqueue.Refresh, UserID: entry.userID, ID: entry.userID}
+	err = syncqueue.AddEvery(refresh, refreshInterval)
+	if err != nil {
+		log.Println("Couldn't queue the chains to be refreshed:", err.Error())
+	}
+	return chain, nil
+}
+
+// job is the sync job that builds the entry's chain.
+func (entry *cachedChain) job() syncqueue.Job {
+	return syncqueue.Job{Kind: syncqueue.BuildChain, UserID: entry.userID, ID: entry.key, Settings: entry.settings}
+}
+
+// entry finds the cache entry for a user's chain built with the settings,
+// making a new one if there isn't one, and marks it as the most recently
+// used. Chains dropped to make room that are still waiting to be built are
+// taken off the sync queue, since nothing is waiting on them anymore.
+func (c *chainCache) entry(userID string, settings markov.Settings) *cachedChain {
+	key := chainKey(userID, settings)
+	c.mu.Lock()
+	if element, exists := c.entries[key]; exists {
+		c.order.MoveToFront(element)
+		c.mu.Unlock()
+		return element.Value.(*cachedChain)
+	}
+	entry := &cachedChain{key: key, userID: userID, settings: settings}
+	c.entries[key] = c.order.PushFront(entry)
+	var evicted []*cachedChain
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedChain).key)
+		evicted = append(evicted, oldest.Value.(*cachedChain))
+	}
+	c.mu.Unlock()
+	for _, old := range evicted {
+		old.mu.Lock()
+		built := old.built
+		old.mu.Unlock()
+		if built {
+			continue
+		}
+		err := syncqueue.Forget(old.job())
+		if err != nil {
+			log.Println("Couldn't take the chain off the sync queue:", err.Error())
+		}
+	}
+	return entry
+}
+
+// chainKey identifies a user's chain built with the given settings.
+func chainKey(userID string, settings markov.Settings) string {
+	location := "UTC"
+	if settings.Location != nil {
+		location = settings.Location.String()
+	}
+	return fmt.Sprint(userID, "|", settings.Order, "|", settings.SessionGap, "|", settings.SkipGap, "|",
+		settings.PenalizeSkips, "|", settings.HalfLife, "|", settings.Hours, "|", settings.Weekdays, "|", location, "|",
+		settings.Canonical, "|", settings.Reverse)
+}
+
+// boundSettings rounds settings to the ones chains are built with, so only
+// so many different chains can be built for a user. Hours and weekdays are
+// widened to the listening contexts they're in, and the timezone is rounded
+// to the hour. Canonicalization rules are only four switches, and only come
+// from the request when the user saves them. Requests can't ask for gaps
+// or half-lives other than the choices above (see chainSettings), so any
+// others are left at their defaults.
+func boundSettings(settings markov.Settings) markov.Settings {
+	if settings.Order < 1 {
+		settings.Order = 1
+	}
+	if settings.Order > markov.MaxOrder {
+		settings.Order = markov.MaxOrder
+	}
+	if settings.SessionGap <= 0 {
+		settings.SessionGap = markov.DefaultSessionGap
+	}
+	if !isChoice(settings.SessionGap, sessionGaps) {
+		settings.SessionGap = markov.DefaultSessionGap
+	}
+	if !isChoice(settings.SkipGap, skipGaps) {
+		settings.SkipGap = 0
+	}
+	if settings.SkipGap == 0 {
+		// Skips can't be penalized without a skip gap.
+		settings.PenalizeSkips = false
+	}
+	if !isChoice(settings.HalfLife, halfLives) {
+		settings.HalfLife = 0
+	}
+	settings.Hours = boundHours(settings.Hours)
+	settings.Weekdays = boundWeekdays(settings.Weekdays)
+	// The timezone only changes which songs are in the hours and weekdays.
+	if settings.Hours == 0 && settings.Weekdays == 0 {
+		settings.Location = nil
+	} else {
+		settings.Location = boundLocation(settings.Location)
+	}
+	return settings
+}
+
+// boundHours widens an hour mask to the whole of each time of day in
+// contextHours that it has any hours in. Every hour is the same as no mask.
+func boundHours(mask uint32) uint32 {
+	var bounded uint32
+	for _, hours := range contextHours {
+		period := markov.HourMask(hours...)
+		if mask&period != 0 {
+			bounded |= period
+		}
+	}
+	if bounded == markov.HourMask(allHours()...) {
+		return 0
+	}
+	return bounded
+}
+
+// allHours are the hours of the day.
+func allHours() []int {
+	hours := make([]int, 24)
+	for i := range hours {
+		hours[i] = i
+	}
+	return hours
+}
+
+// boundWeekdays widens a weekday mask to weekdays or the weekend in
+// contextDays, or no mask if it has days in both.
+func boundWeekdays(mask uint8) uint8 {
+	var bounded uint8
+	var periods int
+	for _, days := range contextDays {
+		period := markov.WeekdayMask(days...)
+		if mask&period != 0 {
+			bounded |= period
+			periods++
+		}
+	}
+	if periods == len(contextDays) {
+		return 0
+	}
+	return bounded
+}
+
+// boundLocation rounds a timezone to a fixed one at the whole number of hours
+// from UTC it is now, so users in the same part of the world share chains.
+func boundLocation(loc *time.Location) *time.Location {
+	if loc == nil {
+		return nil
+	}
+	_, offset := time.Now().In(loc).Zone()
+	hours := int(math.Floor(float64(offset)/time.Hour.Seconds() + 0.5))
+	if hours == 0 {
+		return time.UTC
+	}
+	// Etc/GMT zones are named with the opposite sign to their offset.
+	bounded, err := time.LoadLocation(fmt.Sprintf("Etc/GMT%+d", -hours))
+	if err != nil {
+		return time.UTC
+	}
+	return bounded
+}
+
+// isChoice reports whether d is one of the choices.
+func isChoice(d time.Duration, choices []time.Duration) bool {
+	for _, choice := range choices {
+		if d == choice {
+			return true
+		}
+	}
+	return false
+}
+
+// checkChoice returns an error listing the choices in units if d isn't
+// one of them, so a request isn't silently given a different setting than
+// it asked for.
+func checkChoice(setting string, d time.Duration, choices []time.Duration, unit time.Duration, units string) error {
+	if isChoice(d, choices) {
+		return nil
+	}
+	allowed := make([]string, len(choices))
+	for i, choice := range choices {
+		allowed[i] = strconv.FormatInt(int64(choice/unit), 10)
+	}
+	return errors.New("The " + setting + " has to be " + strings.Join(allowed[:len(allowed)-1], ", ") +
+		" or " + allowed[len(allowed)-1] + " " + units + ".")
+}
+
+// writeChainError writes back why a chain couldn't be found. A chain that's
+// still being built, or can't be queued yet, asks the user to try again in
+// a bit.
+func writeChainError(w http.ResponseWriter, err error) {
+	if err == errSyncing || err == syncqueue.ErrTooManyJobs {
+		w.WriteHeader(503)
+		e, err := json.Marshal(friendlyError{err.Error()})
+		if err == nil {
+			w.Write(e)
+		}
+		return
+	}
+	w.WriteHeader(500)
+	log.Println("Couldn't load the chain:", err.Error())
+	w.Write([]byte("An error occurred. Please try again later."))
+}
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/snyderks/spotkov-web/markov"
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// timezonePrefix is the Redis key prefix for a user's timezone setting.
+const timezonePrefix = "timezone."
+
+// contextHours are the hours of the day for each named listening context.
+var contextHours = map[string][]int{
+	"morning":   {5, 6, 7, 8, 9, 10, 11},
+	"afternoon": {12, 13, 14, 15, 16},
+	"evening":   {17, 18, 19, 20, 21},
+	"night":     {22, 23, 0, 1, 2, 3, 4},
+}
+
+// contextDays are the days of the week for each named listening context.
+var contextDays = map[string][]time.Weekday{
+	"weekday": {time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday},
+	"weekend": {time.Saturday, time.Sunday},
+}
+
+// userLocation finds the timezone to read a user's listening times in for a
+// playlist request. A timezone passed in the request is saved as the user's
+// setting if the request is from the Spotify user who owns their settings
+// (see ownsSettings). Otherwise the saved setting is used, or UTC without one.
+func userLocation(req playlistRequest) (*time.Location, error) {
+	saved := ""
+	err := lastFm.ReadCache(req.LastFmUsername, timezonePrefix, &saved)
+	if err != nil {
+		saved = ""
+	}
+	timezone := saved
+	if len(req.Timezone) > 0 && req.Timezone != saved {
+		if _, err := time.LoadLocation(req.Timezone); err != nil {
+			return nil, errors.New("The timezone " + req.Timezone + " isn't valid.")
+		}
+		if ownsSettings(req.LastFmUsername, req.Token) {
+			timezone = req.Timezone
+			err = lastFm.WriteCache(req.LastFmUsername, timezonePrefix, timezone)
+			if err != nil {
+				log.Println("Couldn't save the timezone:", err.Error())
+			}
+		}
+	}
+	loc, err := time.LoadLocation(timezone)
+	if err != nil {
+		return time.UTC, nil
+	}
+	return loc, nil
+}
+
+// applyListeningContext limits the chain settings to the hours and days in
+// the request. The context can be "now" (the hours around the current time,
+// on weekdays or weekends depending on today), a time of day
+// ("morning", "afternoon", "evening" or "night"), or "weekday" or "weekend".
+// Explicit hours and weekdays take the place of the ones from the context.
+func applyListeningContext(settings markov.Settings, req playlistRequest) (markov.Settings, error) {
+	var hours []int
+	var days []time.Weekday
+	switch req.Context {
+	case "":
+	case "now":
+		now := time.Now().In(settings.Location)
+		hours = []int{(now.Hour() + 23) % 24, now.Hour(), (now.Hour() + 1) % 24}
+		if now.Weekday() == time.Saturday || now.Weekday() == time.Sunday {
+			days = contextDays["weekend"]
+		} else {
+			days = contextDays["weekday"]
+		}
+	default:
+		var hoursFound, daysFound bool
+		hours, hoursFound = contextHours[req.Context]
+		days, daysFound = contextDays[req.Context]
+		if !hoursFound && !daysFound {
+			return settings, errors.New("The listening context " + req.Context + " isn't one Spotkov knows about.")
+		}
+	}
+	if len(req.Hours) > 0 {
+		hours = req.Hours
+	}
+	if len(req.Weekdays) > 0 {
+		days = make([]time.Weekday, len(req.Weekdays))
+		for i, day := range req.Weekdays {
+			days[i] = time.Weekday(day)
+		}
+	}
+	settings.Hours = markov.HourMask(hours...)
+	settings.Weekdays = markov.WeekdayMask(days...)
+	return settings, nil
+}
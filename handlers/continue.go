@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+
+	"github.com/snyderks/spotkov-web/markov"
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// continueRequest is the expected format for a client request to add songs
+// to the end of a playlist. Length is how many songs to add, and the rest of
+// the playlist request's settings are used the same way, other than the ones
+// for where the playlist starts.
+type continueRequest struct {
+	playlistRequest
+	// Songs are the playlist so far, in order.
+	Songs []songRequest `json:"songs"`
+}
+
+func continuePlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(403)
+		return
+	}
+	maxBytes := 50000 // Room for a long playlist, but not much more.
+	if r.ContentLength > int64(maxBytes) {
+		return
+	}
+	var requestBody []byte
+	requestBody, err := ioutil.ReadAll(r.Body)
+	err = r.Body.Close()
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	req := continueRequest{}
+	err = json.Unmarshal(requestBody, &req)
+	if err != nil {
+		w.WriteHeader(400)
+		e, err := json.Marshal(friendlyError{"Reading the playlist request failed."})
+		if err == nil {
+			w.Write(e)
+		}
+		return
+	}
+	length, err := parseLength(req.Length)
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	settings, err := chainSettings(req.playlistRequest)
+	if err != nil {
+		w.WriteHeader(400)
+		e, err := json.Marshal(friendlyError{err.Error()})
+		if err == nil {
+			w.Write(e)
+		}
+		return
+	}
+	chain, err := chains.get(req.LastFmUsername, settings)
+	if err != nil {
+		writeChainError(w, err)
+		return
+	}
+	seed := newSeed()
+	if req.Seed != nil {
+		seed = *req.Seed
+	}
+	list := make([]lastFm.Song, len(req.Songs))
+	for i, song := range req.Songs {
+		list[i] = lastFm.Song{Title: song.Title, Artist: song.Artist}
+	}
+	picks, err := markov.ContinueSongList(list, length, playlistConstraints(req.Constraints),
+		chain, req.Temperature, rand.New(rand.NewSource(seed)))
+	if err != nil {
+		// Some new songs are still worth sending back.
+		if len(picks) == 0 {
+			w.WriteHeader(400)
+			e, err := json.Marshal(friendlyError{err.Error()})
+			if err == nil {
+				w.Write(e)
+			}
+			return
+		}
+	}
+	listJSON, err := json.Marshal(playlistResponse{Seed: seed, Songs: picks})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Write(listJSON)
+}
@@ -8,11 +8,15 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"log"
+	"math/rand"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/snyderks/spotkov-web/markov"
+	"github.com/snyderks/spotkov-web/syncqueue"
 	"github.com/snyderks/spotkov/lastFm"
-	"github.com/snyderks/spotkov/markov"
 )
 
 func createLastFmPlaylist(w http.ResponseWriter, r *http.Request) {
@@ -36,22 +40,130 @@ func createLastFmPlaylist(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	songs, err := lastFm.ReadLastFMSongs(req.LastFmUsername)
+	if req.Best > 0 {
+		writeBestPlaylists(w, req)
+		return
+	}
+	seed := newSeed()
+	if req.Seed != nil {
+		seed = *req.Seed
+	}
+	list, status, err := getSongsForRequest(req, seed)
+	if err != nil {
+		// A partial playlist is still worth sending back.
+		if len(list) == 0 {
+			writePlaylistError(w, status, err)
+			return
+		}
+	}
+	listJSON, err := json.Marshal(playlistResponse{Seed: seed, Songs: list})
 	if err != nil {
 		w.WriteHeader(500)
-		print("Couldn't read songs from Last.FM. Error: ", err.Error())
-		w.Write([]byte("An error occurred. Please try again later."))
 		return
 	}
+	w.Write(listJSON)
+}
 
-	list, err := getSongsForRequest(w, req, songs)
+// writePlaylistError writes back why a playlist couldn't be made with the
+// status getSongsForRequest or bestPlaylistsForRequest returned with it.
+// Anything other than a problem with the request gets a generic message.
+func writePlaylistError(w http.ResponseWriter, status int, err error) {
+	message := err.Error()
+	if status == 500 {
+		message = "Couldn't create the playlist. Try again."
+	}
+	w.WriteHeader(status)
+	e, err := json.Marshal(friendlyError{message})
+	if err == nil {
+		w.Write(e)
+	}
+}
+
+// newSeed picks a seed for a request that didn't pass one.
+// It's kept within 53 bits so it survives being a JavaScript number
+// when the client sends it back.
+func newSeed() int64 {
+	return time.Now().UnixNano() & (1<<53 - 1)
+}
+
+// getSongsForRequest generates the playlist for a request with the seed.
+// If there's an error, it returns the status to write back with it, which is
+// 400 when the error is a problem with the request to show the user, and 503
+// when the user's history is still being read or can't be yet.
+// The list is only shorter than requested, along with an error, if there
+// weren't enough songs to fill it.
+func getSongsForRequest(req playlistRequest, seed int64) ([]markov.Pick, int, error) {
+	length, err := parseLength(req.Length)
 	if err != nil {
-		fmt.Println("couldn't make the song list")
-		w.WriteHeader(500)
-		w.Write([]byte("Couldn't create the playlist. Try again."))
-		return
+		return nil, 400, errors.New("The length of the playlist wasn't a number.")
+	}
+	settings, err := chainSettings(req)
+	if err != nil {
+		return nil, 400, err
+	}
+	if req.End && len(req.Seeds) > 0 {
+		return nil, 400, errors.New("A playlist can't end on a song when it starts from several.")
+	}
+	settings.Reverse = req.End
+	chain, err := chains.get(req.LastFmUsername, settings)
+	if err == errSyncing || err == syncqueue.ErrTooManyJobs {
+		return nil, 503, err
+	}
+	if err != nil {
+		log.Println("Couldn't load the chain:", err.Error())
+		return nil, 500, err
+	}
+	r := rand.New(rand.NewSource(seed))
+	if req.Surprise && len(req.Seeds) == 0 {
+		song, err := chain.SurpriseSong(r)
+		if err != nil {
+			return nil, 400, err
+		}
+		req.Title, req.Artist = song.Title, song.Artist
+	}
+	var list []markov.Pick
+	if req.End {
+		list, err = markov.GenerateEndingSongList(length, playlistConstraints(req.Constraints),
+			lastFm.Song{Title: req.Title, Artist: req.Artist},
+			chain,
+			req.Temperature,
+			r)
+		return list, 400, err
+	}
+	if len(req.Seeds) > 0 {
+		seeds := make([]markov.Seed, len(req.Seeds))
+		for i, s := range req.Seeds {
+			seeds[i] = markov.Seed{
+				Song:   lastFm.Song{Title: s.Title, Artist: s.Artist},
+				Weight: s.Weight,
+			}
+		}
+		list, err = markov.GenerateBlendedSongList(length, playlistConstraints(req.Constraints), seeds, req.Interleave,
+			chain, req.Temperature, r)
+		return list, 400, err
+	}
+	list, err = markov.GenerateSongList(length, playlistConstraints(req.Constraints),
+		lastFm.Song{Title: req.Title, Artist: req.Artist},
+		chain,
+		req.Temperature,
+		r)
+	return list, 400, err
+}
+
+// maxBestPlaylists is the most playlists a request for the most likely ones gets.
+const maxBestPlaylists = 10
+
+// writeBestPlaylists writes back the most likely playlists for a request.
+func writeBestPlaylists(w http.ResponseWriter, req playlistRequest) {
+	lists, status, err := bestPlaylistsForRequest(req)
+	if err != nil {
+		// Shorter playlists are still worth sending back.
+		if len(lists) == 0 {
+			writePlaylistError(w, status, err)
+			return
+		}
 	}
-	listJSON, err := json.Marshal(list)
+	listJSON, err := json.Marshal(bestPlaylistsResponse{Playlists: lists})
 	if err != nil {
 		w.WriteHeader(500)
 		return
@@ -59,13 +171,116 @@ func createLastFmPlaylist(w http.ResponseWriter, r *http.Request) {
 	w.Write(listJSON)
 }
 
-func getSongsForRequest(w http.ResponseWriter, req playlistRequest, songs []lastFm.Song) ([]lastFm.Song, error) {
-	length, err := strconv.Atoi(req.Length)
+// bestPlaylistsForRequest finds the most likely playlists for a request.
+// If there's an error, it returns the status to write back with it, the same
+// way getSongsForRequest does. The playlists are only shorter than requested,
+// along with an error, if there weren't enough songs to fill them.
+func bestPlaylistsForRequest(req playlistRequest) ([]markov.ScoredList, int, error) {
+	length, err := parseLength(req.Length)
+	if err != nil {
+		return nil, 400, errors.New("The length of the playlist wasn't a number.")
+	}
+	settings, err := chainSettings(req)
+	if err != nil {
+		return nil, 400, err
+	}
+	n := req.Best
+	if n > maxBestPlaylists {
+		n = maxBestPlaylists
+	}
+	settings.Reverse = req.End
+	chain, err := chains.get(req.LastFmUsername, settings)
+	if err == errSyncing || err == syncqueue.ErrTooManyJobs {
+		return nil, 503, err
+	}
+	if err != nil {
+		log.Println("Couldn't load the chain:", err.Error())
+		return nil, 500, err
+	}
+	if req.Surprise {
+		seed := newSeed()
+		if req.Seed != nil {
+			seed = *req.Seed
+		}
+		song, err := chain.SurpriseSong(rand.New(rand.NewSource(seed)))
+		if err != nil {
+			return nil, 400, err
+		}
+		req.Title, req.Artist = song.Title, song.Artist
+	}
+	bestSongLists := markov.BestSongLists
+	if req.End {
+		bestSongLists = markov.BestEndingSongLists
+	}
+	lists, err := bestSongLists(length, n, playlistConstraints(req.Constraints),
+		lastFm.Song{Title: req.Title, Artist: req.Artist},
+		chain)
+	return lists, 400, err
+}
+
+// chainSettings reads how to build the chain from a playlist request.
+func chainSettings(req playlistRequest) (markov.Settings, error) {
+	loc, err := userLocation(req)
+	if err != nil {
+		return markov.Settings{}, err
+	}
+	if req.SessionGapMinutes != 0 {
+		err = checkChoice("session gap", time.Duration(req.SessionGapMinutes)*time.Minute, sessionGaps, time.Minute, "minutes")
+		if err != nil {
+			return markov.Settings{}, err
+		}
+	}
+	if req.SkipSeconds != 0 {
+		err = checkChoice("skip gap", time.Duration(req.SkipSeconds)*time.Second, skipGaps, time.Second, "seconds")
+		if err != nil {
+			return markov.Settings{}, err
+		}
+	}
+	if req.HalfLifeDays != 0 {
+		err = checkChoice("half-life", time.Duration(req.HalfLifeDays*float64(day)), halfLives, day, "days")
+		if err != nil {
+			return markov.Settings{}, err
+		}
+	}
+	settings := markov.Settings{
+		Order:         req.Order,
+		SessionGap:    time.Duration(req.SessionGapMinutes) * time.Minute,
+		SkipGap:       time.Duration(req.SkipSeconds) * time.Second,
+		PenalizeSkips: req.PenalizeSkips,
+		HalfLife:      time.Duration(req.HalfLifeDays * float64(day)),
+		Location:      loc,
+		Canonical:     requestCanonicalRules(req),
+	}
+	return applyListeningContext(settings, req)
+}
+
+// playlistConstraints reads the rules for the songs in a playlist from a request.
+func playlistConstraints(req constraintsRequest) markov.Constraints {
+	constraints := markov.Constraints{
+		ExcludeArtists: req.ExcludeArtists,
+		ArtistSpacing:  1,
+		MinPlays:       req.MinPlays,
+	}
+	for _, track := range req.ExcludeTracks {
+		constraints.ExcludeSongs = append(constraints.ExcludeSongs,
+			lastFm.BaseSong{Artist: track.Artist, Title: track.Title})
+	}
+	if req.ArtistSpacing != nil {
+		constraints.ArtistSpacing = *req.ArtistSpacing
+	}
+	if req.NotPlayedInDays > 0 {
+		constraints.PlayedBefore = time.Now().AddDate(0, 0, -req.NotPlayedInDays)
+	}
+	return constraints
+}
+
+// parseLength reads the length of a playlist from a request,
+// keeping it between 1 and 200 songs.
+func parseLength(s string) (int, error) {
+	length, err := strconv.Atoi(s)
 	// These lines prevent a number from being too large or too small.
 	if err != nil {
-		fmt.Println("couldn't convert length to int")
-		w.WriteHeader(400)
-		return nil, errors.New("Length passed was invalid. Atoi error: " + err.Error())
+		return 0, errors.New("Length passed was invalid. Atoi error: " + err.Error())
 	}
 	if length < 1 {
 		length = 1
@@ -73,9 +288,7 @@ func getSongsForRequest(w http.ResponseWriter, req playlistRequest, songs []last
 	if length > 200 {
 		length = 200
 	}
-	return markov.GenerateSongList(length, 1,
-		lastFm.Song{Title: req.Title, Artist: req.Artist},
-		markov.BuildChain(songs))
+	return length, nil
 }
 
 func openPlaylistRequest(r *http.Request) (playlistRequest, error) {
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/snyderks/spotkov-web/markov"
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// defaultNextSongs and maxNextSongs are how many songs are returned
+// for a "what's next" request if it doesn't say, and at most.
+const (
+	defaultNextSongs = 10
+	maxNextSongs     = 50
+)
+
+// nextRequest is the expected format for a client request for the
+// songs most likely to be played after a song.
+type nextRequest struct {
+	Title          string `json:"title"`
+	Artist         string `json:"artist"`
+	LastFmUsername string `json:"lastFmUsername"`
+	// K is how many songs to return, from 1 to maxNextSongs.
+	K int `json:"k"`
+}
+
+// nextResponse is returned with the songs most likely to be played next.
+type nextResponse struct {
+	Songs []markov.Prediction `json:"songs"`
+}
+
+func nextSongsHandler(w http.ResponseWriter, r *http.Request) {
+	maxBytes := 4000 // NOTHING should be sending 4KB requests to this.
+	if r.ContentLength > int64(maxBytes) {
+		return
+	}
+	var requestBody []byte
+	requestBody, err := ioutil.ReadAll(r.Body)
+	err = r.Body.Close()
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	req := nextRequest{}
+	err = json.Unmarshal(requestBody, &req)
+	if err != nil {
+		w.WriteHeader(400)
+		e, err := json.Marshal(friendlyError{"Reading the request failed."})
+		if err == nil {
+			w.Write(e)
+		}
+		return
+	}
+	k := req.K
+	if k <= 0 {
+		k = defaultNextSongs
+	} else if k > maxNextSongs {
+		k = maxNextSongs
+	}
+	chain, err := chains.get(req.LastFmUsername, markov.Settings{
+		Canonical: userCanonicalRules(req.LastFmUsername),
+	})
+	if err != nil {
+		writeChainError(w, err)
+		return
+	}
+	predictions, err := markov.Predict(lastFm.Song{Title: req.Title, Artist: req.Artist}, k, chain)
+	if err != nil {
+		w.WriteHeader(400)
+		e, err := json.Marshal(friendlyError{err.Error()})
+		if err == nil {
+			w.Write(e)
+		}
+		return
+	}
+	resp, err := json.Marshal(nextResponse{Songs: predictions})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Write(resp)
+}
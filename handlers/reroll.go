@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/snyderks/spotkov-web/markov"
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// defaultAlternatives and maxAlternatives are how many songs are returned
+// to replace one in a playlist if the request doesn't say, and at most.
+const (
+	defaultAlternatives = 5
+	maxAlternatives     = 20
+)
+
+// rerollRequest is the expected format for a client request for songs to
+// replace one in a playlist. The playlist request's settings for the chain
+// and constraints are used the same way.
+type rerollRequest struct {
+	playlistRequest
+	// Songs are the playlist, in order.
+	Songs []songRequest `json:"songs"`
+	// Index is where the song to replace is in Songs, starting at 0.
+	Index int `json:"index"`
+	// K is how many songs to return, from 1 to maxAlternatives.
+	K int `json:"k"`
+}
+
+// rerollResponse is returned with the songs that best fit in place of the
+// one requested, best first.
+type rerollResponse struct {
+	Songs []markov.Alternative `json:"songs"`
+}
+
+func rerollSongHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(403)
+		return
+	}
+	maxBytes := 50000 // Room for a long playlist, but not much more.
+	if r.ContentLength > int64(maxBytes) {
+		return
+	}
+	var requestBody []byte
+	requestBody, err := ioutil.ReadAll(r.Body)
+	err = r.Body.Close()
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	req := rerollRequest{}
+	err = json.Unmarshal(requestBody, &req)
+	if err != nil {
+		w.WriteHeader(400)
+		e, err := json.Marshal(friendlyError{"Reading the request failed."})
+		if err == nil {
+			w.Write(e)
+		}
+		return
+	}
+	k := req.K
+	if k <= 0 {
+		k = defaultAlternatives
+	} else if k > maxAlternatives {
+		k = maxAlternatives
+	}
+	settings, err := chainSettings(req.playlistRequest)
+	if err != nil {
+		w.WriteHeader(400)
+		e, err := json.Marshal(friendlyError{err.Error()})
+		if err == nil {
+			w.Write(e)
+		}
+		return
+	}
+	chain, err := chains.get(req.LastFmUsername, settings)
+	if err != nil {
+		writeChainError(w, err)
+		return
+	}
+	list := make([]lastFm.Song, len(req.Songs))
+	for i, song := range req.Songs {
+		list[i] = lastFm.Song{Title: song.Title, Artist: song.Artist}
+	}
+	alternatives, err := markov.Alternatives(list, req.Index, k, playlistConstraints(req.Constraints), chain)
+	if err != nil {
+		w.WriteHeader(400)
+		e, err := json.Marshal(friendlyError{err.Error()})
+		if err == nil {
+			w.Write(e)
+		}
+		return
+	}
+	resp, err := json.Marshal(rerollResponse{Songs: alternatives})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Write(resp)
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"log"
+
+	"github.com/snyderks/spotkov/lastFm"
+	"golang.org/x/oauth2"
+)
+
+// ownerPrefix is the Redis key prefix for the Spotify user who can save
+// a Last.FM user's settings.
+const ownerPrefix = "owner."
+
+// ownsSettings reports whether a request's Spotify token is for the user who
+// can save a Last.FM user's settings. The first Spotify user to save settings
+// for a Last.FM user is the only one who can from then on, so anyone else
+// passing the username can't change them.
+func ownsSettings(userID string, token oauth2.Token) bool {
+	if len(token.AccessToken) == 0 {
+		return false
+	}
+	client, err := initializeClientWithToken(token)
+	if err != nil {
+		return false
+	}
+	user, err := client.CurrentUser()
+	if err != nil {
+		log.Println("Couldn't read the Spotify user saving settings:", err.Error())
+		return false
+	}
+	owner := ""
+	err = lastFm.ReadCache(userID, ownerPrefix, &owner)
+	if err == nil && len(owner) > 0 {
+		return owner == user.ID
+	}
+	err = lastFm.WriteCache(userID, ownerPrefix, user.ID)
+	if err != nil {
+		log.Println("Couldn't save who owns the settings:", err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/snyderks/spotkov-web/markov"
+	"github.com/snyderks/spotkov-web/similarstore"
+	"github.com/snyderks/spotkov-web/syncqueue"
+)
+
+// defaultSimilarSongs and maxSimilarSongs are how many songs are returned
+// for a "more like this" request if it doesn't say, and at most.
+const (
+	defaultSimilarSongs = 10
+	maxSimilarSongs     = similarstore.MaxSimilar
+)
+
+// similarMaxAge is how long similar songs are used before they're found
+// again with the songs played since. Older ones are still used until then.
+const similarMaxAge = 24 * time.Hour
+
+// errSongNotFound is returned when a song isn't in the user's history.
+var errSongNotFound = errors.New("The song you entered couldn't be found. Please try again.")
+
+// similarRequest is the expected format for a client request for the
+// songs in a user's history most like a song.
+type similarRequest struct {
+	Title          string `json:"title"`
+	Artist         string `json:"artist"`
+	LastFmUsername string `json:"lastFmUsername"`
+	// K is how many songs to return, from 1 to maxSimilarSongs.
+	K int `json:"k"`
+}
+
+// similarResponse is returned with the songs most like the one requested.
+type similarResponse struct {
+	Songs []markov.SimilarSong `json:"songs"`
+}
+
+// similarSettings are what a user's history is split into sessions and
+// canonicalized with to find similar songs.
+func similarSettings(userID string) markov.Settings {
+	return markov.Settings{
+		SessionGap: markov.DefaultSessionGap,
+		Canonical:  userCanonicalRules(userID),
+	}
+}
+
+// similarSongsHandler finds the songs most like one in the user's history,
+// as found by the sync command (see storedSimilarSongs).
+func similarSongsHandler(w http.ResponseWriter, r *http.Request) {
+	maxBytes := 4000 // NOTHING should be sending 4KB requests to this.
+	if r.ContentLength > int64(maxBytes) {
+		return
+	}
+	var requestBody []byte
+	requestBody, err := ioutil.ReadAll(r.Body)
+	err = r.Body.Close()
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	req := similarRequest{}
+	err = json.Unmarshal(requestBody, &req)
+	if err != nil {
+		w.WriteHeader(400)
+		e, err := json.Marshal(friendlyError{"Reading the request failed."})
+		if err == nil {
+			w.Write(e)
+		}
+		return
+	}
+	k := req.K
+	if k <= 0 {
+		k = defaultSimilarSongs
+	} else if k > maxSimilarSongs {
+		k = maxSimilarSongs
+	}
+	similar, err := storedSimilarSongs(req.LastFmUsername, req.Artist, req.Title)
+	if err == errSongNotFound {
+		w.WriteHeader(400)
+		e, err := json.Marshal(friendlyError{err.Error()})
+		if err == nil {
+			w.Write(e)
+		}
+		return
+	}
+	if err != nil {
+		writeChainError(w, err)
+		return
+	}
+	if len(similar) > k {
+		similar = similar[:k]
+	}
+	resp, err := json.Marshal(similarResponse{Songs: similar})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Write(resp)
+}
+
+// storedSimilarSongs looks up the songs most like one in the user's history,
+// most alike first, as the sync command found them. If no artist is given,
+// the most played song with the title is used. They're used however old they
+// are, and queued to be found again once they're older than similarMaxAge.
+// Returns errSyncing if they haven't been found with the user's settings yet,
+// and errSongNotFound if the song isn't in the history.
+func storedSimilarSongs(userID string, artist string, title string) ([]markov.SimilarSong, error) {
+	settings := similarSettings(userID)
+	job := syncqueue.Job{Kind: syncqueue.IndexSimilar, UserID: userID, ID: userID, Settings: settings}
+	info, err := similarstore.ReadInfo(userID)
+	if err != nil && err != similarstore.ErrNotStored {
+		return nil, err
+	}
+	found := err == nil && info.SessionGap == settings.SessionGap && info.Canonical == settings.Canonical
+	if !found {
+		if err := syncqueue.Failed(job); err != nil {
+			// Let the user know once, then try again on the next request.
+			return nil, err
+		}
+		err = syncqueue.Add(job)
+		if err != nil {
+			return nil, err
+		}
+		return nil, errSyncing
+	}
+	if time.Since(info.Indexed) >= similarMaxAge {
+		err = syncqueue.Add(job)
+		if err != nil {
+			log.Println("Couldn't queue the similar songs to be found again:", err.Error())
+		}
+	}
+	key := markov.SimilarKey(settings.Canonical, artist, title)
+	if len(artist) == 0 {
+		var exists bool
+		key, exists, err = similarstore.MostPlayed(userID, markov.SimilarTitleKey(settings.Canonical, title))
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, errSongNotFound
+		}
+	}
+	similar, exists, err := similarstore.Similar(userID, key)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, errSongNotFound
+	}
+	return similar, nil
+}
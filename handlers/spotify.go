@@ -10,7 +10,9 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/snyderks/spotkov-web/canonical"
 	"github.com/snyderks/spotkov-web/randString"
+	"github.com/snyderks/spotkov/lastFm"
 	"github.com/snyderks/spotkov/spotifyPlaylistGenerator"
 )
 
@@ -94,7 +96,11 @@ func postPlaylistToSpotify(w http.ResponseWriter, r *http.Request) {
 		}
 		return
 	}
-	spotifyPlaylistGenerator.CreatePlaylist(req.Songs, &client, user.ID)
+	rules := canonical.Rules{}
+	if len(req.LastFmUsername) > 0 {
+		rules = userCanonicalRules(req.LastFmUsername)
+	}
+	spotifyPlaylistGenerator.CreatePlaylist(searchableSongs(req.Songs, rules), &client, user.ID)
 	// If this succeeded, need to return the token used to authorize the request.
 	tok, err := client.Token()
 
@@ -117,6 +123,24 @@ func postPlaylistToSpotify(w http.ResponseWriter, r *http.Request) {
 	w.Write(b)
 }
 
+// searchableSongs leaves out the songs that are the same track as one before
+// them by the canonicalization rules, so a version or featured artist in how
+// Last.FM reported one doesn't put it in the playlist twice. Songs are still
+// searched for in Spotify the way they were scrobbled.
+func searchableSongs(songs []lastFm.Song, rules canonical.Rules) []lastFm.Song {
+	seen := make(map[string]bool, len(songs))
+	searchable := make([]lastFm.Song, 0, len(songs))
+	for _, song := range songs {
+		key := canonical.Key(rules.Artist(song.Artist)) + "\x1e" + canonical.Key(rules.Title(song.Title))
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		searchable = append(searchable, song)
+	}
+	return searchable
+}
+
 func spotifyUserHandler(w http.ResponseWriter, r *http.Request) {
 	client, err := initializeClient(r)
 	if err != nil {
@@ -0,0 +1,7 @@
+package handlers
+
+import "errors"
+
+// errSyncing is returned for a request that needs something still being
+// built from the user's whole history by the sync command.
+var errSyncing = errors.New("Your listening history is still being read from Last.FM. Please try again in a minute.")
@@ -12,6 +12,8 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/snyderks/spotkov-web/canonical"
+	"github.com/snyderks/spotkov-web/markov"
 	"github.com/snyderks/spotkov/configRead"
 	"github.com/snyderks/spotkov/lastFm"
 	"github.com/zmb3/spotify"
@@ -32,6 +34,106 @@ type playlistRequest struct {
 	Title          string       `json:"title"`
 	Artist         string       `json:"artist"`
 	LastFmUsername string       `json:"lastFmUsername"`
+	// Order is how many of the previous songs are used to pick the next
+	// one, from 1 to markov.MaxOrder. Out of range values are clamped.
+	Order int `json:"order"`
+	// SessionGapMinutes is the longest gap between two songs for them
+	// to count as played one after the other. Defaults to an hour.
+	// It has to be one of sessionGaps, and the same goes for SkipSeconds
+	// and HalfLifeDays with skipGaps and halfLives, or the request is turned
+	// away with the ones it can be.
+	SessionGapMinutes int `json:"sessionGapMinutes"`
+	// SkipSeconds marks songs followed by another this quickly as skipped.
+	// Transitions to skipped songs are left out, or counted against the
+	// song with PenalizeSkips. Leaving it out turns off skip detection.
+	SkipSeconds   int  `json:"skipSeconds"`
+	PenalizeSkips bool `json:"penalizeSkips"`
+	// HalfLifeDays makes older transitions count less, halving every
+	// HalfLifeDays days. Leaving it out counts every transition the same.
+	HalfLifeDays float64 `json:"halfLifeDays"`
+	// Context limits the history to songs played at certain times, such as
+	// "now", "morning" or "weekend" (see applyListeningContext). Hours (0 to 23)
+	// and Weekdays (0 is Sunday) can be passed instead or to override it.
+	Context  string `json:"context"`
+	Hours    []int  `json:"hours"`
+	Weekdays []int  `json:"weekdays"`
+	// Timezone is the IANA name of the user's timezone, such as
+	// "America/New_York". It's only used if Token is for the Spotify user who
+	// owns the Last.FM user's settings, and saved for later requests that
+	// leave it out. Otherwise the saved timezone is used.
+	Timezone string `json:"timezone"`
+	// Seed makes the playlist reproducible. The same history and seed
+	// always give the same playlist. A new one is picked if it's left out.
+	Seed *int64 `json:"seed"`
+	// Temperature sharpens (below 1) or flattens (above 1) how likely the
+	// next song is to be the most common one. Leaving it out keeps the
+	// play counts as they are.
+	Temperature float64 `json:"temperature"`
+	// Seeds start the playlist from several songs instead of Title and Artist.
+	Seeds []seedRequest `json:"seeds"`
+	// Interleave switches between the seeds' songs throughout the playlist
+	// instead of going through them one after another.
+	Interleave bool `json:"interleave"`
+	// Constraints are rules every song after the seed has to follow.
+	Constraints constraintsRequest `json:"constraints"`
+	// Best returns that many of the most likely playlists from Title and
+	// Artist instead of one random one, up to maxBestPlaylists.
+	Best int `json:"best"`
+	// End makes the playlist end on Title and Artist instead of starting
+	// with them. It can't be used with Seeds.
+	End bool `json:"end"`
+	// Surprise picks the song to start from (or end on) in place of Title
+	// and Artist, favouring the songs most central to the user's history
+	// (see markov.Chain.SurpriseSong). The pick depends on Seed too, so the
+	// same seed gives the same playlist. It's ignored with Seeds.
+	Surprise bool `json:"surprise"`
+	// Canonical are the rules for which of the user's tracks are the same
+	// track. They're only used if Token is for the Spotify user who owns the
+	// Last.FM user's settings, and saved for later requests that leave them
+	// out. Otherwise the saved rules are used.
+	Canonical *canonical.Rules `json:"canonical"`
+}
+
+// seedRequest is one of the songs to start a playlist from. Weight is how
+// much of the playlist comes from around it compared to the other seeds,
+// with 0 being the same as 1.
+type seedRequest struct {
+	Title  string  `json:"title"`
+	Artist string  `json:"artist"`
+	Weight float64 `json:"weight"`
+}
+
+// constraintsRequest holds the rules for the songs in a requested playlist.
+type constraintsRequest struct {
+	ExcludeArtists []string      `json:"excludeArtists"`
+	ExcludeTracks  []songRequest `json:"excludeTracks"`
+	// ArtistSpacing is the fewest songs between two by the same artist.
+	// Defaults to 1, so the same artist is never played twice in a row.
+	ArtistSpacing *int `json:"artistSpacing"`
+	// MinPlays is the fewest times a song has to have been played.
+	MinPlays int `json:"minPlays"`
+	// NotPlayedInDays leaves out songs played in the last number of days.
+	NotPlayedInDays int `json:"notPlayedInDays"`
+}
+
+// songRequest is a song passed in a request.
+type songRequest struct {
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+}
+
+// playlistResponse is returned with a generated playlist. Contains the seed
+// used so the playlist can be generated again. Songs the generator had to
+// jump to are marked with the fallback that found them.
+type playlistResponse struct {
+	Seed  int64         `json:"seed"`
+	Songs []markov.Pick `json:"songs"`
+}
+
+// bestPlaylistsResponse is returned with the most likely playlists,
+// most likely first.
+type bestPlaylistsResponse struct {
+	Playlists []markov.ScoredList `json:"playlists"`
 }
 
 // spotifyPlaylistCreation is the expected format for a client request
@@ -41,6 +143,10 @@ type spotifyPlaylistCreation struct {
 	Token        oauth2.Token  `json:"token"`
 	PlaylistName string        `json:"playlistName"`
 	Songs        []lastFm.Song `json:"songs"`
+	// LastFmUsername picks whose canonicalization rules decide which songs
+	// are the same track, which are only added once. Everything is
+	// canonicalized without it.
+	LastFmUsername string `json:"lastFmUsername"`
 }
 
 // SpotifyResponse is returned on successful interactions with the API
@@ -172,6 +278,12 @@ func SetUpAPICalls() {
 	http.HandleFunc("/callback", spotifyAuthHandler)
 	http.HandleFunc("/api/getSpotifyUser", spotifyUserHandler)
 	http.HandleFunc("/api/getPlaylist", createLastFmPlaylist)
+	http.HandleFunc("/api/getBridgePlaylist", createBridgePlaylist)
+	http.HandleFunc("/api/continuePlaylist", continuePlaylistHandler)
+	http.HandleFunc("/api/rerollSong", rerollSongHandler)
+	http.HandleFunc("/api/nextSongs", nextSongsHandler)
+	http.HandleFunc("/api/similarSongs", similarSongsHandler)
+	http.HandleFunc("/api/centralSongs", centralSongsHandler)
 	http.HandleFunc("/api/createPlaylist", postPlaylistToSpotify)
 	http.HandleFunc("/api/songMatches", autocompleteSongHandler)
 	http.HandleFunc("/api/artistMatches", autocompleteArtistHandler)
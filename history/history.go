@@ -0,0 +1,56 @@
+// Package history reads users' listening histories the way lastFm caches
+// them in Redis.
+package history
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// CachePrefix is the Redis key prefix lastFm keeps each user's whole
+// history under, as of the last time it was read from Last.FM.
+const CachePrefix = "songCache."
+
+// cachedSongs is the way lastFm caches a user's whole history.
+type cachedSongs struct {
+	Songs []lastFm.Song
+}
+
+// Cached reads a user's whole history, oldest first, as of the last time it
+// was read from Last.FM, without asking Last.FM for anything.
+// Returns an error if there isn't one cached.
+func Cached(userID string) ([]lastFm.Song, error) {
+	history := cachedSongs{}
+	err := lastFm.ReadCache(userID, CachePrefix, &history)
+	if err != nil {
+		return nil, err
+	}
+	if len(history.Songs) == 0 {
+		return nil, errors.New("There aren't any songs cached for " + userID + ".")
+	}
+	sortOldestFirst(history.Songs)
+	return history.Songs, nil
+}
+
+// Read reads a user's whole history, oldest first, asking Last.FM for the
+// songs played since it was last cached and caching them along with the rest.
+// It can take a while for a long history, so it's only done by the sync
+// command (see syncqueue).
+func Read(userID string) ([]lastFm.Song, error) {
+	songs, err := lastFm.ReadLastFMSongs(userID)
+	if err != nil {
+		return nil, err
+	}
+	sortOldestFirst(songs)
+	return songs, nil
+}
+
+// sortOldestFirst sorts songs by when they were played, since lastFm adds
+// the songs read since the last time to the start of the cache.
+func sortOldestFirst(songs []lastFm.Song) {
+	sort.SliceStable(songs, func(i, j int) bool {
+		return songs[i].Timestamp.Before(songs[j].Timestamp)
+	})
+}
@@ -0,0 +1,101 @@
+package markov
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// bestBeamWidth is the fewest partial playlists kept at each step
+// when looking for the most likely playlists.
+const bestBeamWidth = 100
+
+// ScoredList is a playlist along with the log probability of the chain
+// making each of its transitions.
+type ScoredList struct {
+	Songs          []lastFm.Song
+	LogProbability float64
+}
+
+// BestSongLists finds the n most likely playlists of the given length that start
+// with startingSong, most likely first. Nothing is picked at random, so the same
+// chain always gives the same playlists.
+// Each song follows the longest prefix at the end of the playlist that has a suffix
+// allowed by the constraints, backing off the same way GenerateSongList does.
+// It keeps the most likely playlists at each step (a beam search), so it won't
+// always find the most likely playlists overall.
+// If no playlist can reach the length, the longest ones found are returned
+// along with an error.
+func BestSongLists(length int, n int, constraints Constraints, startingSong lastFm.Song, chain Chain) ([]ScoredList, error) {
+	constraints = constraints.prepare(chain)
+	startingSong, exists := resolveSeed(chain, startingSong)
+	if !exists {
+		return nil, errors.New("The song you entered couldn't be found. Please try again.")
+	}
+	width := bestBeamWidth
+	if n > width {
+		width = n
+	}
+
+	var genError error
+	beam := []ScoredList{{Songs: []lastFm.Song{startingSong}}}
+	for step := 1; step < length; step++ {
+		var next []ScoredList
+		for _, list := range beam {
+			prefix, suffixes := allowedSuffixes(list.Songs, constraints, chain)
+			for _, suffix := range suffixes {
+				songs := make([]lastFm.Song, len(list.Songs), len(list.Songs)+1)
+				copy(songs, list.Songs)
+				next = append(next, ScoredList{
+					Songs:          append(songs, lastFm.Song{Artist: suffix.Artist, Title: suffix.Name}),
+					LogProbability: list.LogProbability + math.Log(suffix.Weight/prefix.Weight),
+				})
+			}
+		}
+		if len(next) == 0 {
+			genError = errors.New("There aren't enough songs in your history to fill the playlist.")
+			break
+		}
+		// Suffixes are always in the same order, so a stable sort
+		// keeps ties in the same order too.
+		sort.SliceStable(next, func(i, j int) bool {
+			return next[i].LogProbability > next[j].LogProbability
+		})
+		if len(next) > width {
+			next = next[:width]
+		}
+		beam = next
+	}
+	if len(beam) > n {
+		beam = beam[:n]
+	}
+	return beam, genError
+}
+
+// allowedSuffixes finds the suffixes that can be added to the end of list.
+// They come from the first prefix, in the order pickNext tries them,
+// that has any suffix allowed by the constraints.
+// Returns the prefix's suffixes and the allowed ones, or nothing if none are.
+func allowedSuffixes(list []lastFm.Song, constraints Constraints, chain Chain) (Suffixes, []Suffix) {
+	for _, prefix := range contexts(list, chain.Order) {
+		suffixes, exists := chain.suffixes(chain.prefixKey(prefix))
+		if !exists || suffixes.Weight <= 0 {
+			continue
+		}
+		var allowed []Suffix
+		for _, suffix := range suffixes.Suffixes {
+			if suffix.Weight <= 0 {
+				continue
+			}
+			if constraints.allowed(list, lastFm.Song{Artist: suffix.Artist, Title: suffix.Name}) {
+				allowed = append(allowed, suffix)
+			}
+		}
+		if len(allowed) > 0 {
+			return suffixes, allowed
+		}
+	}
+	return Suffixes{}, nil
+}
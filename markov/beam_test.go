@@ -0,0 +1,55 @@
+package markov
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+func TestBestSongLists(t *testing.T) {
+	chain := BuildChain(playHistory([]string{
+		"A - One", "A - Two", "A - Four", "", "A - One", "A - Two", "A - Four", "",
+		"A - One", "A - Two", "A - Four", "", "A - One", "A - Three", "A - Four",
+	}), Settings{})
+	seed := lastFm.Song{Artist: "A", Title: "One"}
+	lists, err := BestSongLists(3, 5, Constraints{}, seed, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		titles  []string
+		logProb float64
+	}{
+		{[]string{"One", "Two", "Four"}, math.Log(3.0 / 4)},
+		{[]string{"One", "Three", "Four"}, math.Log(1.0 / 4)},
+	}
+	if len(lists) != len(want) {
+		t.Fatalf("got %d playlists, want %d", len(lists), len(want))
+	}
+	for i, w := range want {
+		if got := songTitles(lists[i].Songs); !reflect.DeepEqual(got, w.titles) || !closeTo(lists[i].LogProbability, w.logProb) {
+			t.Errorf("playlist %d got %q with %v, want %q with %v", i, got, lists[i].LogProbability, w.titles, w.logProb)
+		}
+	}
+	again, _ := BestSongLists(3, 5, Constraints{}, seed, chain)
+	if !reflect.DeepEqual(lists, again) {
+		t.Error("got different playlists from the same chain")
+	}
+
+	lists, err = BestSongLists(3, 1, Constraints{ExcludeSongs: []lastFm.BaseSong{{Artist: "A", Title: "Two"}}}, seed, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lists) != 1 || !reflect.DeepEqual(songTitles(lists[0].Songs), want[1].titles) {
+		t.Errorf("got %+v, want only the playlist without Two", lists)
+	}
+
+	// Nothing follows Four, so it goes back to One for Three,
+	// and then there's nothing left.
+	lists, err = BestSongLists(5, 1, Constraints{}, seed, chain)
+	if err == nil || len(lists) != 1 || len(lists[0].Songs) != 4 {
+		t.Errorf("got %+v and %v, want the longest playlist and an error", lists, err)
+	}
+}
@@ -0,0 +1,146 @@
+package markov
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// Seed is one of the songs a blended playlist starts from. Weight is how much
+// of the playlist comes from around the song compared to the other seeds.
+type Seed struct {
+	Song   lastFm.Song
+	Weight float64
+}
+
+// GenerateBlendedSongList creates one playlist from several seeds, with one walk
+// through the chain starting at each of them. With interleave, the walks take
+// turns adding songs so the playlist keeps moving between the seeds. Otherwise
+// each walk adds all of its songs before the next one starts, so the playlist
+// moves from one seed's region to the next.
+// Each walk picks from its own songs (see GenerateSongList), but every song is
+// checked against the whole playlist so no song appears twice and the artist
+// spacing holds where the walks meet. Seeds that would break those rules are
+// still walked from but left out of the playlist. A walk that reaches a dead
+// end jumps to another song and carries on from there (see pickFallback).
+// It returns a list of songs and an optional error.
+func GenerateBlendedSongList(length int, constraints Constraints, seeds []Seed, interleave bool, chain Chain, temperature float64, r *rand.Rand) ([]Pick, error) {
+	if len(seeds) == 0 {
+		return nil, errors.New("No songs were entered to start the playlist from.")
+	}
+	s := newSampler(temperature, r)
+	constraints = constraints.prepare(chain)
+
+	walks := make([][]lastFm.Song, len(seeds))
+	for i, seed := range seeds {
+		song, exists := resolveSeed(chain, seed.Song)
+		if !exists {
+			return nil, errors.New("\"" + seed.Song.Title + "\" couldn't be found. Please try again.")
+		}
+		walks[i] = []lastFm.Song{song}
+	}
+	shares := splitLength(length, seeds)
+	added := make([]int, len(seeds))
+	seeded := make([]bool, len(seeds))
+	done := make([]bool, len(seeds))
+
+	var genError error
+	list := make([]lastFm.Song, 0, length)
+	picks := make([]Pick, 0, length)
+	for len(list) < length {
+		i := nextWalk(added, shares, done, interleave)
+		if i < 0 {
+			genError = errors.New("There aren't enough songs in your history to fill the playlist.")
+			break
+		}
+		if !seeded[i] {
+			seeded[i] = true
+			if constraints.allowed(list, walks[i][0]) {
+				list = append(list, walks[i][0])
+				picks = append(picks, Pick{Song: walks[i][0]})
+				added[i]++
+			}
+			continue
+		}
+		pick, foundSuffix := pickNext(walks[i], list, constraints, chain, s)
+		if !foundSuffix {
+			pick, foundSuffix = pickFallback(list, constraints, chain, r)
+		}
+		if !foundSuffix {
+			done[i] = true
+			continue
+		}
+		walks[i] = append(walks[i], pick.Song)
+		list = append(list, pick.Song)
+		picks = append(picks, pick)
+		added[i]++
+	}
+	return picks, genError
+}
+
+// nextWalk picks which walk adds the next song to a blended playlist.
+// When interleaving, it's the walk furthest behind its share. Otherwise it's
+// the first walk that hasn't added its share yet.
+// Walks that can't go any further are skipped, and once every other walk has
+// added its share the rest of the playlist goes to the walks that can.
+// Returns -1 if no walk can add a song.
+func nextWalk(added []int, shares []int, done []bool, interleave bool) int {
+	for _, overShare := range []bool{false, true} {
+		next := -1
+		nextProgress := math.Inf(1)
+		for i := range added {
+			if done[i] || (!overShare && added[i] >= shares[i]) {
+				continue
+			}
+			if !interleave {
+				return i
+			}
+			progress := float64(added[i]+1) / float64(shares[i]+1)
+			if progress < nextProgress {
+				next = i
+				nextProgress = progress
+			}
+		}
+		if next >= 0 {
+			return next
+		}
+	}
+	return -1
+}
+
+// splitLength divides length between the seeds in proportion to their weights,
+// giving any songs left over from rounding to the seeds that lost the most.
+// A weight that isn't above zero counts as 1.
+func splitLength(length int, seeds []Seed) []int {
+	weights := make([]float64, len(seeds))
+	total := 0.0
+	for i, seed := range seeds {
+		weights[i] = seed.Weight
+		if weights[i] <= 0 {
+			weights[i] = 1
+		}
+		total += weights[i]
+	}
+	shares := make([]int, len(seeds))
+	remainders := make([]float64, len(seeds))
+	given := 0
+	for i, weight := range weights {
+		exact := float64(length) * weight / total
+		shares[i] = int(exact)
+		remainders[i] = exact - float64(shares[i])
+		given += shares[i]
+	}
+	for ; given < length; given++ {
+		most := 0
+		for i := range remainders {
+			if remainders[i] > remainders[most] {
+				most = i
+			}
+		}
+		shares[most]++
+		remainders[most] = -1
+	}
+	return shares
+}
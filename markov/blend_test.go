@@ -0,0 +1,86 @@
+package markov
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+func TestSplitLength(t *testing.T) {
+	tests := []struct {
+		length  int
+		weights []float64
+		want    []int
+	}{
+		{10, []float64{1, 1}, []int{5, 5}},
+		{10, []float64{3, 1}, []int{8, 2}},
+		{10, []float64{1, 1, 1}, []int{4, 3, 3}},
+		{5, []float64{0, -1}, []int{3, 2}},
+	}
+	for _, test := range tests {
+		seeds := make([]Seed, len(test.weights))
+		for i, weight := range test.weights {
+			seeds[i].Weight = weight
+		}
+		if got := splitLength(test.length, seeds); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d songs with weights %v: got %v, want %v", test.length, test.weights, got, test.want)
+		}
+	}
+}
+
+func TestGenerateBlendedSongList(t *testing.T) {
+	// Two groups of songs that are never played together,
+	// so each walk stays in its own.
+	chain := BuildChain(playHistory([]string{
+		"A - A1", "B - A2", "A - A3", "B - A4", "A - A1",
+		"",
+		"C - C1", "D - C2", "C - C3", "D - C4", "C - C1",
+	}), Settings{})
+	seeds := []Seed{
+		{Song: lastFm.Song{Artist: "A", Title: "A1"}},
+		{Song: lastFm.Song{Artist: "C", Title: "C1"}},
+	}
+	for _, interleave := range []bool{false, true} {
+		picks, err := GenerateBlendedSongList(6, Constraints{}, seeds, interleave, chain, 0, rand.New(rand.NewSource(1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := titles(picks)
+		if len(got) != 6 {
+			t.Fatalf("interleave %v: got %q, want 6 songs", interleave, got)
+		}
+		second := 3
+		if interleave {
+			second = 1
+		}
+		want := []string{"A", "C", "A", "C", "A", "C"}
+		if !interleave {
+			want = []string{"A", "A", "A", "C", "C", "C"}
+		}
+		for i, title := range got {
+			if title[:1] != want[i] {
+				t.Errorf("interleave %v: got %q, want songs from the seeds in the order %v", interleave, got, want)
+				break
+			}
+		}
+		if got[0] != "A1" || got[second] != "C1" {
+			t.Errorf("interleave %v: got %q, want the seeds first and at %d", interleave, got, second)
+		}
+		seen := make(map[string]bool)
+		for _, title := range got {
+			if seen[title] {
+				t.Errorf("interleave %v: got %q twice in %q", interleave, title, got)
+			}
+			seen[title] = true
+		}
+		again, _ := GenerateBlendedSongList(6, Constraints{}, seeds, interleave, chain, 0, rand.New(rand.NewSource(1)))
+		if !reflect.DeepEqual(picks, again) {
+			t.Errorf("interleave %v: got different playlists for the same seed", interleave)
+		}
+	}
+	if _, err := GenerateBlendedSongList(6, Constraints{}, nil, false, chain, 0, rand.New(rand.NewSource(1))); err == nil {
+		t.Error("got a playlist without any seeds")
+	}
+}
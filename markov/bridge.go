@@ -0,0 +1,242 @@
+package markov
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// ErrUnreachable is returned by Bridge when no chain of songs the user has
+// listened to leads from the first song to the last one within the search's
+// limits (see bridgeMaxExpanded).
+var ErrUnreachable = errors.New("There's no way to get from the first song to the last one in your history in a playlist that long. Try a longer playlist or a different pair of songs.")
+
+// bridgeBeamWidth is how many partial paths are kept at each step
+// when looking for a bridge of the requested length.
+const bridgeBeamWidth = 100
+
+// bridgeMaxExpanded is the most songs the search for the most likely path
+// looks at the songs after, so a pair of songs far apart in a big chain
+// can't keep a request busy, or fetch most of a stored chain.
+const bridgeMaxExpanded = 2000
+
+// bridgePath is a partial path through the chain, made of song keys.
+type bridgePath struct {
+	keys    []string
+	logProb float64 // sum of the log probabilities of each transition
+}
+
+// contains reports whether the song key is already in the path.
+func (p bridgePath) contains(key string) bool {
+	for _, k := range p.keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Bridge creates a playlist that starts with from and ends with to, walking the
+// single-song transitions in the chain. More likely transitions are preferred,
+// and of the paths found the one with the length closest to length is returned
+// along with its log probability.
+// Paths are at most twice as long as length.
+// Returns ErrUnreachable if to can't be reached from from in that many songs.
+func Bridge(length int, from lastFm.Song, to lastFm.Song, chain Chain) ([]lastFm.Song, float64, error) {
+	fromKey, exists := findPrefix(chain, from)
+	if !exists {
+		return nil, 0, errors.New("The first song you entered couldn't be found. Please try again.")
+	}
+	toKey, exists := findSong(chain, to)
+	if !exists {
+		return nil, 0, errors.New("The last song you entered couldn't be found. Please try again.")
+	}
+	if fromKey == toKey {
+		return nil, 0, errors.New("The first and last songs have to be different.")
+	}
+
+	maxSteps := 2 * (length - 1)
+	if maxSteps < 1 {
+		maxSteps = 1
+	}
+
+	// The most likely path is always a candidate, and if there isn't one
+	// there's nothing to search for.
+	best, found := mostLikelyPath(chain, fromKey, toKey, maxSteps)
+	if !found {
+		return nil, 0, ErrUnreachable
+	}
+	candidates := []bridgePath{best}
+
+	// Walk outwards from the first song keeping only the most likely
+	// paths, and note every one that lands on the last song.
+	beam := []bridgePath{{keys: []string{fromKey}}}
+	for step := 0; step < maxSteps && len(beam) > 0; step++ {
+		var next []bridgePath
+		for _, path := range beam {
+			suffixes, exists := chain.suffixes(path.keys[len(path.keys)-1])
+			if !exists {
+				continue
+			}
+			for _, suffix := range suffixes.Suffixes {
+				key := chain.key(lastFm.Song{Artist: suffix.Artist, Title: suffix.Name})
+				if suffix.Weight <= 0 || path.contains(key) {
+					continue
+				}
+				keys := make([]string, len(path.keys), len(path.keys)+1)
+				copy(keys, path.keys)
+				extended := bridgePath{
+					keys:    append(keys, key),
+					logProb: path.logProb + transitionLogProb(suffix, suffixes),
+				}
+				if key == toKey {
+					candidates = append(candidates, extended)
+				} else {
+					next = append(next, extended)
+				}
+			}
+		}
+		sort.SliceStable(next, func(i, j int) bool {
+			return next[i].logProb > next[j].logProb
+		})
+		if len(next) > bridgeBeamWidth {
+			next = next[:bridgeBeamWidth]
+		}
+		beam = next
+	}
+
+	chosen := candidates[0]
+	for _, path := range candidates[1:] {
+		diff := absInt(len(path.keys) - length)
+		chosenDiff := absInt(len(chosen.keys) - length)
+		if diff < chosenDiff || (diff == chosenDiff && path.logProb > chosen.logProb) {
+			chosen = path
+		}
+	}
+
+	list := make([]lastFm.Song, len(chosen.keys))
+	for i, key := range chosen.keys {
+		song := chain.Songs[key]
+		list[i] = lastFm.Song{Artist: song.Artist, Title: song.Title}
+	}
+	return list, chosen.logProb, nil
+}
+
+// findSong looks for a song anywhere in the chain, including songs that are
+// only ever suffixes, such as the last song played.
+func findSong(chain Chain, song lastFm.Song) (string, bool) {
+	key := chain.key(song)
+	if _, exists := chain.Songs[key]; exists {
+		return key, true
+	}
+	return findPrefix(chain, song)
+}
+
+// transitionLogProb is the log probability of suffix following its prefix.
+func transitionLogProb(suffix Suffix, suffixes Suffixes) float64 {
+	return math.Log(suffix.Weight / suffixes.Weight)
+}
+
+// absInt returns the absolute value of an int.
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// mostLikelyPath finds the path from one song to another with the highest
+// probability using Dijkstra's algorithm, with -log(probability) as the cost
+// of each transition. Only paths of up to maxSteps transitions are followed,
+// and the search gives up after looking at the songs after bridgeMaxExpanded
+// songs. Returns false if there's no path within those limits.
+func mostLikelyPath(chain Chain, fromKey string, toKey string, maxSteps int) (bridgePath, bool) {
+	costs := map[string]float64{fromKey: 0}
+	previous := make(map[string]string)
+	done := make(map[string]bool)
+	queue := &pathQueue{{key: fromKey}}
+	expanded := 0
+	for queue.Len() > 0 {
+		item := heap.Pop(queue).(pathQueueItem)
+		if done[item.key] {
+			continue
+		}
+		done[item.key] = true
+		if item.key == toKey {
+			break
+		}
+		if item.steps >= maxSteps {
+			continue
+		}
+		if expanded == bridgeMaxExpanded {
+			return bridgePath{}, false
+		}
+		expanded++
+		suffixes, exists := chain.suffixes(item.key)
+		if !exists {
+			continue
+		}
+		for _, suffix := range suffixes.Suffixes {
+			if suffix.Weight <= 0 {
+				continue
+			}
+			key := chain.key(lastFm.Song{Artist: suffix.Artist, Title: suffix.Name})
+			cost := item.cost - transitionLogProb(suffix, suffixes)
+			if c, seen := costs[key]; !done[key] && (!seen || cost < c) {
+				costs[key] = cost
+				previous[key] = item.key
+				heap.Push(queue, pathQueueItem{key: key, cost: cost, steps: item.steps + 1})
+			}
+		}
+	}
+	if !done[toKey] {
+		return bridgePath{}, false
+	}
+	keys := []string{toKey}
+	for key := toKey; key != fromKey; {
+		key = previous[key]
+		keys = append(keys, key)
+	}
+	// Built backwards, so flip it around.
+	for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+		keys[i], keys[j] = keys[j], keys[i]
+	}
+	return bridgePath{keys: keys, logProb: -costs[toKey]}, true
+}
+
+// pathQueueItem is a song waiting to be visited by mostLikelyPath.
+type pathQueueItem struct {
+	key   string
+	cost  float64
+	steps int // transitions from the first song
+}
+
+// pathQueue is a min-heap of songs ordered by their cost.
+type pathQueue []pathQueueItem
+
+// Heap interface implementation
+func (q pathQueue) Len() int {
+	return len(q)
+}
+
+func (q pathQueue) Less(i, j int) bool {
+	return q[i].cost < q[j].cost
+}
+
+func (q pathQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *pathQueue) Push(x interface{}) {
+	*q = append(*q, x.(pathQueueItem))
+}
+
+func (q *pathQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return item
+}
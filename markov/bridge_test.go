@@ -0,0 +1,81 @@
+package markov
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// songTitles is the titles of the songs, in order.
+func songTitles(songs []lastFm.Song) []string {
+	list := make([]string, len(songs))
+	for i, song := range songs {
+		list[i] = song.Title
+	}
+	return list
+}
+
+func TestBridge(t *testing.T) {
+	chain := BuildChain(playHistory([]string{
+		"A - 1", "A - 2", "A - 3", "A - 4", "A - 5",
+		"",
+		"A - 1", "A - 5",
+	}), Settings{})
+	from := lastFm.Song{Artist: "A", Title: "1"}
+	to := lastFm.Song{Artist: "A", Title: "5"}
+	tests := []struct {
+		length int
+		want   []string
+	}{
+		{2, []string{"1", "5"}},
+		{5, []string{"1", "2", "3", "4", "5"}},
+	}
+	for _, test := range tests {
+		list, _, err := Bridge(test.length, from, to, chain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := songTitles(list); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("length %d: got %q, want %q", test.length, got, test.want)
+		}
+	}
+	if _, _, err := Bridge(3, from, from, chain); err == nil {
+		t.Error("got a bridge from a song to itself")
+	}
+	if _, _, err := Bridge(3, lastFm.Song{Artist: "A", Title: "2"}, from, chain); err != ErrUnreachable {
+		t.Errorf("got %v going against the transitions, want ErrUnreachable", err)
+	}
+}
+
+func TestBridgeTooFar(t *testing.T) {
+	plays := make([]string, bridgeMaxExpanded+100)
+	for i := range plays {
+		plays[i] = "A - " + strconv.Itoa(i)
+	}
+	chain := BuildChain(playHistory(plays), Settings{})
+	from := lastFm.Song{Artist: "A", Title: "0"}
+	tests := []struct {
+		length int
+		to     int
+	}{
+		// Longer than the playlist can stretch to.
+		{3, 5},
+		// Further than the search looks.
+		{len(plays), len(plays) - 1},
+	}
+	for _, test := range tests {
+		to := lastFm.Song{Artist: "A", Title: strconv.Itoa(test.to)}
+		if _, _, err := Bridge(test.length, from, to, chain); err != ErrUnreachable {
+			t.Errorf("length %d to %d: got %v, want ErrUnreachable", test.length, test.to, err)
+		}
+	}
+	list, _, err := Bridge(3, from, lastFm.Song{Artist: "A", Title: "4"}, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 5 {
+		t.Errorf("got %q, want every song from 0 to 4", songTitles(list))
+	}
+}
@@ -0,0 +1,100 @@
+package markov
+
+import (
+	"time"
+
+	"github.com/snyderks/spotkov-web/canonical"
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// Constraints are the rules every song added to a generated playlist follows.
+// The zero value allows any song that isn't already in the playlist.
+type Constraints struct {
+	ExcludeArtists []string
+	ExcludeSongs   []lastFm.BaseSong
+	// ArtistSpacing is the fewest songs there have to be between
+	// two songs by the same artist.
+	ArtistSpacing int
+	// MinPlays is the fewest times a song has to have been played.
+	MinPlays int
+	// PlayedBefore leaves out songs played after it, if it's set.
+	PlayedBefore time.Time
+
+	excludedArtists map[string]bool
+	excludedSongs   map[string]bool
+	plays           map[string]int
+	lastPlayed      map[string]time.Time
+	keys            map[lastFm.BaseSong]string
+	artists         map[string]string
+	rules           canonical.Rules
+}
+
+// prepare sets up the constraints to check songs from the chain against.
+func (c Constraints) prepare(chain Chain) Constraints {
+	c.excludedArtists = make(map[string]bool, len(c.ExcludeArtists))
+	for _, artist := range c.ExcludeArtists {
+		c.excludedArtists[compareArtist(chain.settings.Canonical, artist)] = true
+	}
+	c.excludedSongs = make(map[string]bool, len(c.ExcludeSongs))
+	for _, song := range c.ExcludeSongs {
+		c.excludedSongs[songKey(chain.settings.Canonical, song.Artist, song.Title)] = true
+	}
+	c.plays = chain.Plays
+	c.lastPlayed = chain.LastPlayed
+	c.keys = chain.keys
+	c.artists = chain.artists
+	c.rules = chain.settings.Canonical
+	return c
+}
+
+// allowed reports whether song can be added to the end of list.
+// A song can't be in the list twice, there have to be at least ArtistSpacing
+// songs since the last one by the same artist, and the song can't be
+// left out by any of the other constraints.
+func (c Constraints) allowed(list []lastFm.Song, song lastFm.Song) bool {
+	key := lookupKey(c.keys, c.rules, song)
+	artist := lookupArtist(c.artists, c.rules, song.Artist)
+	if c.excludedSongs[key] || c.excludedArtists[artist] {
+		return false
+	}
+	if c.MinPlays > 0 && c.plays[key] < c.MinPlays {
+		return false
+	}
+	if !c.PlayedBefore.IsZero() && c.lastPlayed[key].After(c.PlayedBefore) {
+		return false
+	}
+	// do not add the song if it's already in the list.
+	for _, s := range list {
+		// this is considered a match
+		if lookupKey(c.keys, c.rules, s) == key {
+			return false
+		}
+	}
+	// start at the end and look back over the spacing for the same artist.
+	for checked := 0; checked < c.ArtistSpacing && checked < len(list); checked++ {
+		if lookupArtist(c.artists, c.rules, list[len(list)-1-checked].Artist) == artist {
+			return false
+		}
+	}
+	return true
+}
+
+// allowedAt reports whether song can take the place of the song at index in
+// list. It has to be allowed after the songs before it (see allowed), can't be
+// any of the songs after it, and has to be spaced from their artists too.
+func (c Constraints) allowedAt(list []lastFm.Song, index int, song lastFm.Song) bool {
+	if !c.allowed(list[:index], song) {
+		return false
+	}
+	key := lookupKey(c.keys, c.rules, song)
+	artist := lookupArtist(c.artists, c.rules, song.Artist)
+	for checked, s := range list[index+1:] {
+		if lookupKey(c.keys, c.rules, s) == key {
+			return false
+		}
+		if checked < c.ArtistSpacing && lookupArtist(c.artists, c.rules, s.Artist) == artist {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package markov
+
+import (
+	"testing"
+	"time"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+func TestConstraintsAllowed(t *testing.T) {
+	chain := BuildChain(testHistory(), Settings{})
+	myth := lastFm.Song{Artist: "Beach House", Title: "Myth"}
+	spaceSong := lastFm.Song{Artist: "Beach House", Title: "Space Song"}
+	mykonos := lastFm.Song{Artist: "Fleet Foxes", Title: "Mykonos"}
+	// Space Song was last played on the second day, and Myth on the third.
+	secondDay := time.Date(2018, 1, 3, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		constraints Constraints
+		list        []lastFm.Song
+		song        lastFm.Song
+		want        bool
+	}{
+		{"anything", Constraints{}, nil, myth, true},
+		{"already in the list", Constraints{}, []lastFm.Song{myth, mykonos}, myth, false},
+		{"written differently", Constraints{}, []lastFm.Song{{Artist: "beach house", Title: "MYTH"}}, myth, false},
+		{"excluded artist", Constraints{ExcludeArtists: []string{"beach house"}}, nil, myth, false},
+		{"excluded song", Constraints{ExcludeSongs: []lastFm.BaseSong{{Artist: "Beach House", Title: "Myth"}}}, nil, myth, false},
+		{"other song by an excluded song's artist", Constraints{ExcludeSongs: []lastFm.BaseSong{{Artist: "Beach House", Title: "Myth"}}}, nil, spaceSong, true},
+		{"same artist right before", Constraints{ArtistSpacing: 1}, []lastFm.Song{myth}, spaceSong, false},
+		{"same artist spaced out", Constraints{ArtistSpacing: 1}, []lastFm.Song{myth, mykonos}, spaceSong, true},
+		{"same artist not spaced out enough", Constraints{ArtistSpacing: 2}, []lastFm.Song{myth, mykonos}, spaceSong, false},
+		{"played enough", Constraints{MinPlays: 5}, nil, myth, true},
+		{"not played enough", Constraints{MinPlays: 3}, nil, spaceSong, false},
+		{"not played since", Constraints{PlayedBefore: secondDay}, nil, spaceSong, true},
+		{"played since", Constraints{PlayedBefore: secondDay}, nil, myth, false},
+	}
+	for _, test := range tests {
+		if got := test.constraints.prepare(chain).allowed(test.list, test.song); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestConstraintsAllowedAt(t *testing.T) {
+	chain := BuildChain(testHistory(), Settings{})
+	list := []lastFm.Song{
+		{Artist: "Beach House", Title: "Myth"},
+		{Artist: "Fleet Foxes", Title: "Mykonos"},
+		{Artist: "Grizzly Bear", Title: "Two Weeks"},
+	}
+	constraints := Constraints{ArtistSpacing: 1}.prepare(chain)
+	tests := []struct {
+		song lastFm.Song
+		want bool
+	}{
+		{lastFm.Song{Artist: "Fleet Foxes", Title: "Helplessness Blues"}, true},
+		// Right after a song by the same artist.
+		{lastFm.Song{Artist: "Beach House", Title: "Lazuli"}, false},
+		// Right before a song by the same artist.
+		{lastFm.Song{Artist: "Grizzly Bear", Title: "Yet Again"}, false},
+		// Later in the list.
+		{lastFm.Song{Artist: "Grizzly Bear", Title: "Two Weeks"}, false},
+	}
+	for _, test := range tests {
+		if got := constraints.allowedAt(list, 1, test.song); got != test.want {
+			t.Errorf("%v: got %v, want %v", test.song.Title, got, test.want)
+		}
+	}
+}
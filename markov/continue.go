@@ -0,0 +1,40 @@
+package markov
+
+import (
+	"errors"
+	"math/rand"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// ContinueSongList adds up to n songs to the end of list, a playlist that can
+// come from anywhere, such as one the user is putting together or a generated
+// one they've changed. Songs are picked from the end of list the way
+// GenerateSongList picks them, and checked against all of list so no song is
+// in it twice and the artist spacing holds from its last songs. The songs
+// already in list don't have to be in the chain or follow the constraints.
+// It returns only the new songs, along with an optional error. There are only
+// fewer than n if there aren't enough songs in the history.
+func ContinueSongList(list []lastFm.Song, n int, constraints Constraints, chain Chain, temperature float64, r *rand.Rand) ([]Pick, error) {
+	if len(list) == 0 {
+		return nil, errors.New("No songs were entered to continue from.")
+	}
+	s := newSampler(temperature, r)
+	constraints = constraints.prepare(chain)
+
+	full := make([]lastFm.Song, len(list), len(list)+n)
+	copy(full, list)
+	picks := make([]Pick, 0, n)
+	for i := 0; i < n; i++ {
+		pick, foundSuffix := pickNext(full, full, constraints, chain, s)
+		if !foundSuffix {
+			pick, foundSuffix = pickFallback(full, constraints, chain, r)
+		}
+		if !foundSuffix {
+			return picks, errors.New("There aren't enough songs in your history to continue the playlist.")
+		}
+		full = append(full, pick.Song)
+		picks = append(picks, pick)
+	}
+	return picks, nil
+}
@@ -0,0 +1,50 @@
+package markov
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+func TestContinueSongList(t *testing.T) {
+	chain := BuildChain(testHistory(), Settings{Order: 2})
+	list := []lastFm.Song{
+		// Not in the history at all.
+		{Artist: "Somebody Else", Title: "Something"},
+		{Artist: "Beach House", Title: "Myth"},
+		{Artist: "Grizzly Bear", Title: "Two Weeks"},
+	}
+	picks, err := ContinueSongList(list, 3, Constraints{ArtistSpacing: 1}, chain, 0, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(picks) != 3 {
+		t.Fatalf("got %q, want 3 new songs", titles(picks))
+	}
+	full := list
+	for _, pick := range picks {
+		if !(Constraints{ArtistSpacing: 1}).prepare(chain).allowed(full, pick.Song) {
+			t.Errorf("got %q after %q, which isn't allowed", pick.Title, songTitles(full))
+		}
+		full = append(full, pick.Song)
+	}
+	// Space Song is the only song played after Myth and Two Weeks.
+	if picks[0].Title != "Space Song" || len(picks[0].Prefix) != 2 {
+		t.Errorf("got %q after %v, want Space Song after the last two songs", picks[0].Title, picks[0].Prefix)
+	}
+	again, _ := ContinueSongList(list, 3, Constraints{ArtistSpacing: 1}, chain, 0, rand.New(rand.NewSource(1)))
+	if !reflect.DeepEqual(picks, again) {
+		t.Error("got different songs for the same seed")
+	}
+
+	// There are only 7 songs in the history.
+	picks, err = ContinueSongList(list, 10, Constraints{}, chain, 0, rand.New(rand.NewSource(1)))
+	if err == nil || len(picks) != 5 {
+		t.Errorf("got %q and %v, want the 5 songs not in the list and an error", titles(picks), err)
+	}
+	if _, err := ContinueSongList(nil, 3, Constraints{}, chain, 0, rand.New(rand.NewSource(1))); err == nil {
+		t.Error("continued an empty playlist")
+	}
+}
@@ -0,0 +1,223 @@
+package markov
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/snyderks/spotkov-web/canonical"
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// Embeddings place each song in a history at a point in space so that songs
+// played in the same sessions are close together, even if one was never
+// played right after the other. They're trained like word embeddings
+// (skip-gram with negative sampling), with each session as a sentence.
+type Embeddings struct {
+	Dimensions int
+	Keys       []string          // song keys, in the order of their vectors
+	Songs      []lastFm.BaseSong // how each song is displayed
+	// Vectors holds Dimensions numbers for each song, one after the other.
+	Vectors []float32
+	// Canonical and SessionGap are the settings the embeddings were trained with.
+	Canonical  canonical.Rules
+	SessionGap time.Duration
+}
+
+// EmbeddingSettings control how embeddings are trained.
+// The zero value of each field uses its default.
+type EmbeddingSettings struct {
+	Dimensions int // size of each song's vector
+	// Window is the most songs on each side of a song in its session
+	// that it's trained to be close to.
+	Window int
+	// Negatives is how many songs picked at random it's trained to be
+	// far from for each one it's trained to be close to.
+	Negatives    int
+	Epochs       int     // passes over the whole history
+	LearningRate float64 // how far vectors move at the start, slowing to nothing by the end
+	// Subsample is how often a song has to be played, as a part of all
+	// plays, before some of its plays are left out so it doesn't crowd
+	// out the rest.
+	Subsample float64
+	Seed      int64
+}
+
+// Default embedding settings.
+const (
+	DefaultDimensions   = 32
+	DefaultWindow       = 5
+	DefaultNegatives    = 5
+	DefaultEpochs       = 5
+	DefaultLearningRate = 0.025
+	DefaultSubsample    = 1e-3
+)
+
+// maxExponent is where the sigmoid is treated as 0 or 1.
+const maxExponent = 6
+
+// withDefaults fills in anything that wasn't set.
+func (s EmbeddingSettings) withDefaults() EmbeddingSettings {
+	if s.Dimensions <= 0 {
+		s.Dimensions = DefaultDimensions
+	}
+	if s.Window <= 0 {
+		s.Window = DefaultWindow
+	}
+	if s.Negatives <= 0 {
+		s.Negatives = DefaultNegatives
+	}
+	if s.Epochs <= 0 {
+		s.Epochs = DefaultEpochs
+	}
+	if s.LearningRate <= 0 {
+		s.LearningRate = DefaultLearningRate
+	}
+	if s.Subsample <= 0 {
+		s.Subsample = DefaultSubsample
+	}
+	return s
+}
+
+// TrainEmbeddings trains embeddings from songs, oldest first.
+// The history is split into sessions by the session gap in settings, and songs
+// are identified with its canonicalization rules, like BuildChain does.
+// The rest of settings are only for chains and aren't used.
+// Training is the same every time for the same history and seed.
+func TrainEmbeddings(songs []lastFm.Song, settings Settings, params EmbeddingSettings) Embeddings {
+	settings = settings.withDefaults()
+	params = params.withDefaults()
+	embeddings := Embeddings{
+		Dimensions: params.Dimensions,
+		Canonical:  settings.Canonical,
+		SessionGap: settings.SessionGap,
+	}
+
+	// Turn the history into sessions of song indexes.
+	keys := make(map[lastFm.BaseSong]string)
+	indexes := make(map[string]int)
+	var plays []int
+	var sessions [][]int
+	var session []int
+	for i, song := range songs {
+		base := lastFm.BaseSong{Artist: song.Artist, Title: song.Title}
+		key := lookupKey(keys, settings.Canonical, song)
+		keys[base] = key
+		index, exists := indexes[key]
+		if !exists {
+			index = len(embeddings.Keys)
+			indexes[key] = index
+			embeddings.Keys = append(embeddings.Keys, key)
+			embeddings.Songs = append(embeddings.Songs, base)
+			plays = append(plays, 0)
+		}
+		plays[index]++
+		if i > 0 && !settings.sameSession(songs[i-1:i+1]) {
+			sessions = append(sessions, session)
+			session = nil
+		}
+		// Repeats of a song aren't anything to learn from.
+		if len(session) == 0 || session[len(session)-1] != index {
+			session = append(session, index)
+		}
+	}
+	sessions = append(sessions, session)
+
+	r := rand.New(rand.NewSource(params.Seed))
+	d := params.Dimensions
+	vectors := make([]float32, len(embeddings.Keys)*d)
+	for i := range vectors {
+		vectors[i] = (r.Float32() - 0.5) / float32(d)
+	}
+	contexts := make([]float32, len(vectors))
+
+	// Negatives are picked in proportion to plays^0.75, like word2vec,
+	// and plays of the most played songs are kept with this chance.
+	negatives := make(CDF, len(plays))
+	keep := make([]float64, len(plays))
+	total := 0.0
+	threshold := params.Subsample * float64(len(songs))
+	for i, count := range plays {
+		total += math.Pow(float64(count), 0.75)
+		negatives[i] = CDFPoint{Total: total, Index: i}
+		keep[i] = math.Min(1, (math.Sqrt(float64(count)/threshold)+1)*threshold/float64(count))
+	}
+
+	steps := params.Epochs * len(songs)
+	step := 0
+	gradient := make([]float32, d)
+	for epoch := 0; epoch < params.Epochs; epoch++ {
+		for _, session := range sessions {
+			kept := make([]int, 0, len(session))
+			for _, index := range session {
+				if r.Float64() < keep[index] {
+					kept = append(kept, index)
+				}
+			}
+			for i, center := range kept {
+				step++
+				rate := float32(params.LearningRate * math.Max(1e-4, 1-float64(step)/float64(steps+1)))
+				window := 1 + r.Intn(params.Window)
+				for j := i - window; j <= i+window; j++ {
+					if j < 0 || j >= len(kept) || j == i || kept[j] == center {
+						continue
+					}
+					input := vectors[kept[j]*d : (kept[j]+1)*d]
+					for k := range gradient {
+						gradient[k] = 0
+					}
+					for n := 0; n <= params.Negatives; n++ {
+						target, label := center, float32(1)
+						if n > 0 {
+							target, label = searchCDF(negatives, r), 0
+							if target == center {
+								continue
+							}
+						}
+						output := contexts[target*d : (target+1)*d]
+						g := (label - sigmoid(dot(input, output))) * rate
+						for k := range gradient {
+							gradient[k] += g * output[k]
+							output[k] += g * input[k]
+						}
+					}
+					for k := range input {
+						input[k] += gradient[k]
+					}
+				}
+			}
+		}
+	}
+	embeddings.Vectors = vectors
+	return embeddings
+}
+
+// vector is the vector of the song at index.
+func (e Embeddings) vector(index int) []float32 {
+	return e.Vectors[index*e.Dimensions : (index+1)*e.Dimensions]
+}
+
+// dot is the dot product of two vectors of the same length.
+func dot(a []float32, b []float32) float32 {
+	sum := float32(0)
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+// norm is the length of a vector.
+func norm(v []float32) float64 {
+	return math.Sqrt(float64(dot(v, v)))
+}
+
+// sigmoid squashes x to between 0 and 1.
+func sigmoid(x float32) float32 {
+	if x > maxExponent {
+		return 1
+	}
+	if x < -maxExponent {
+		return 0
+	}
+	return float32(1 / (1 + math.Exp(-float64(x))))
+}
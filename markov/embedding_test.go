@@ -0,0 +1,65 @@
+package markov
+
+import (
+	"reflect"
+	"testing"
+)
+
+// clusterPlays is sessions of two groups of songs that are never played
+// together, in a different order each time.
+func clusterPlays() []string {
+	groups := [][]string{
+		{"A - One", "A - Two", "A - Three", "A - Four"},
+		{"B - Five", "B - Six", "B - Seven", "B - Eight"},
+	}
+	var plays []string
+	for i := 0; i < 40; i++ {
+		group := groups[i%2]
+		for j := range group {
+			plays = append(plays, group[(i/2+j)%len(group)])
+		}
+		plays = append(plays, "")
+	}
+	return plays[:len(plays)-1]
+}
+
+// cosine is how alike the vectors of the songs at a and b are.
+func cosine(e Embeddings, a int, b int) float64 {
+	return float64(dot(e.vector(a), e.vector(b))) / (norm(e.vector(a)) * norm(e.vector(b)))
+}
+
+func TestTrainEmbeddings(t *testing.T) {
+	songs := playHistory(clusterPlays())
+	// Every play is kept, since every song is played as often as any other.
+	params := EmbeddingSettings{Dimensions: 8, Epochs: 20, Subsample: 1, Seed: 1}
+	embeddings := TrainEmbeddings(songs, Settings{}, params)
+	if len(embeddings.Keys) != 8 || len(embeddings.Songs) != 8 || len(embeddings.Vectors) != 8*8 {
+		t.Fatalf("got %d keys, %d songs and %d numbers, want 8, 8 and 64",
+			len(embeddings.Keys), len(embeddings.Songs), len(embeddings.Vectors))
+	}
+	if embeddings.SessionGap != DefaultSessionGap {
+		t.Errorf("got a session gap of %v, want the default", embeddings.SessionGap)
+	}
+	if again := TrainEmbeddings(songs, Settings{}, params); !reflect.DeepEqual(embeddings, again) {
+		t.Error("got different embeddings with the same seed")
+	}
+
+	// Songs played together are closer than songs that never are.
+	leastTogether, mostApart := 1.0, -1.0
+	for i, a := range embeddings.Songs {
+		for j, b := range embeddings.Songs {
+			if i == j {
+				continue
+			}
+			if c := cosine(embeddings, i, j); a.Artist == b.Artist && c < leastTogether {
+				leastTogether = c
+			} else if a.Artist != b.Artist && c > mostApart {
+				mostApart = c
+			}
+		}
+	}
+	if leastTogether <= mostApart {
+		t.Errorf("got as little as %v for songs played together and as much as %v for songs that aren't",
+			leastTogether, mostApart)
+	}
+}
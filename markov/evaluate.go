@@ -0,0 +1,156 @@
+package markov
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// Evaluation is how well a chain predicts the songs played in a held-out
+// part of a user's history.
+type Evaluation struct {
+	// Transitions is how many transitions in the held-out history were
+	// predicted, counting only the ones BuildChain would have counted.
+	Transitions int
+	// LogLikelihood is the sum of the log probabilities of each held-out
+	// transition. Transitions the chain has never seen are given a small
+	// probability based on how many times the song was played (see smoothedProb).
+	LogLikelihood float64
+	// Perplexity is the exponent of the average negative log likelihood.
+	// It's how many songs the chain is as unsure between as if it were picking
+	// evenly from them, so lower is better.
+	Perplexity float64
+	// HitRate is the fraction of held-out transitions where the song played
+	// next was one of the chain's k most likely, for each k evaluated.
+	HitRate map[int]float64
+	// Coverage is the fraction of held-out transitions where the chain had
+	// a prefix to predict the next song from.
+	Coverage float64
+}
+
+// smoothing is how much of the probability of each transition goes to
+// how many times the song was played overall, so that transitions
+// the chain has never seen don't have a probability of zero.
+const smoothing = 0.01
+
+// SplitHistory sorts the songs oldest first and splits them into the songs
+// played before cutoff, to build a chain from, and the ones played at or
+// after it, to evaluate the chain with.
+func SplitHistory(songs []lastFm.Song, cutoff time.Time) (train []lastFm.Song, heldOut []lastFm.Song) {
+	sorted := make([]lastFm.Song, len(songs))
+	copy(sorted, songs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Timestamp.Before(sorted[j].Timestamp)
+	})
+	i := sort.Search(len(sorted), func(i int) bool {
+		return !sorted[i].Timestamp.Before(cutoff)
+	})
+	return sorted[:i], sorted[i:]
+}
+
+// Evaluate builds a chain from train with the given settings and measures how
+// well it predicts the transitions in heldOut, both oldest first.
+// Each held-out song is predicted from the longest prefix of the songs before
+// it in the same session that the chain has suffixes for, and hit rates are
+// worked out for each of ks.
+// Transitions BuildChain would leave out (repeats, different sessions, times
+// outside the settings' hours and weekdays, and skipped songs) aren't evaluated.
+func Evaluate(train []lastFm.Song, heldOut []lastFm.Song, settings Settings, ks []int) Evaluation {
+	chain := BuildChain(train, settings)
+	return EvaluateChain(chain, heldOut, settings, ks)
+}
+
+// EvaluateChain measures how well an already built chain predicts the
+// transitions in heldOut (see Evaluate). The settings should be the ones
+// the chain was built with.
+func EvaluateChain(chain Chain, heldOut []lastFm.Song, settings Settings, ks []int) Evaluation {
+	settings = settings.withDefaults()
+	eval := Evaluation{HitRate: make(map[int]float64, len(ks))}
+	totalPlays := 0
+	for _, plays := range chain.Plays {
+		totalPlays += plays
+	}
+	covered := 0
+	hits := make([]int, len(ks))
+	for i := 0; i < len(heldOut)-1; i++ {
+		song := heldOut[i]
+		nextSong := heldOut[i+1]
+		nextKey := chain.key(nextSong)
+		if nextKey == chain.key(song) {
+			continue
+		}
+		if !settings.sameSession(heldOut[i:i+2]) || !settings.inContext(song.Timestamp) ||
+			settings.skipped(heldOut, i+1) {
+			continue
+		}
+		eval.Transitions++
+
+		suffixes, found := heldOutSuffixes(chain, heldOut, i, settings)
+		chainProb := 0.0
+		if found {
+			covered++
+			rank := 0
+			for _, suffix := range suffixes.Suffixes {
+				if chain.key(lastFm.Song{Artist: suffix.Artist, Title: suffix.Name}) == nextKey && suffix.Weight > 0 {
+					chainProb = suffix.Weight / suffixes.Weight
+					rank = rankOf(suffix, suffixes)
+					break
+				}
+			}
+			for j, k := range ks {
+				if chainProb > 0 && rank < k {
+					hits[j]++
+				}
+			}
+		}
+		eval.LogLikelihood += math.Log(smoothedProb(chainProb, chain.Plays[nextKey], totalPlays, len(chain.Plays)))
+	}
+	if eval.Transitions > 0 {
+		n := float64(eval.Transitions)
+		eval.Perplexity = math.Exp(-eval.LogLikelihood / n)
+		eval.Coverage = float64(covered) / n
+		for j, k := range ks {
+			eval.HitRate[k] = float64(hits[j]) / n
+		}
+	}
+	return eval
+}
+
+// heldOutSuffixes finds the suffixes to predict the song after heldOut[i] from.
+// It uses the longest prefix ending at i, played within one session, that has
+// suffixes in the chain. Returns false if there isn't one.
+func heldOutSuffixes(chain Chain, heldOut []lastFm.Song, i int, settings Settings) (Suffixes, bool) {
+	n := chain.Order
+	for n > 1 && (i-n+1 < 0 || !settings.sameSession(heldOut[i-n+1:i+1])) {
+		n--
+	}
+	for ; n >= 1; n-- {
+		suffixes, exists := chain.suffixes(chain.prefixKey(heldOut[i-n+1 : i+1]))
+		if exists && suffixes.Weight > 0 {
+			return suffixes, true
+		}
+	}
+	return Suffixes{}, false
+}
+
+// rankOf is how many of the suffixes are more likely than suffix.
+func rankOf(suffix Suffix, suffixes Suffixes) int {
+	rank := 0
+	for _, other := range suffixes.Suffixes {
+		if other.Weight > suffix.Weight {
+			rank++
+		}
+	}
+	return rank
+}
+
+// smoothedProb mixes the chain's probability of a transition with the chance
+// of the song being played at all, given how many times it was played out of
+// the total. Every song is counted as played once more than it was, including
+// songs the chain has never seen, so no transition has a probability of zero.
+func smoothedProb(chainProb float64, plays int, totalPlays int, songs int) float64 {
+	playProb := float64(plays+1) / float64(totalPlays+songs+1)
+	return (1-smoothing)*chainProb + smoothing*playProb
+}
@@ -0,0 +1,53 @@
+package markov
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+func TestSplitHistory(t *testing.T) {
+	songs := testHistory()
+	cutoff := songs[10].Timestamp
+	// Newest first, the way Last.FM lists them.
+	reversed := make([]lastFm.Song, len(songs))
+	for i, song := range songs {
+		reversed[len(songs)-1-i] = song
+	}
+	train, heldOut := SplitHistory(reversed, cutoff)
+	if !reflect.DeepEqual(train, songs[:10]) || !reflect.DeepEqual(heldOut, songs[10:]) {
+		t.Errorf("got %d and %d songs, want the 10 before the cutoff and the rest sorted", len(train), len(heldOut))
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	songs := playHistory([]string{
+		"A - One", "A - Two", "A - Three", "", "A - One", "A - Three",
+		"",
+		"A - One", "A - Three", "",
+		// Repeats aren't evaluated, and Four was never played before.
+		"A - Two", "A - Two", "", "A - Four", "A - One",
+	})
+	train, heldOut := SplitHistory(songs, songs[5].Timestamp)
+	eval := Evaluate(train, heldOut, Settings{}, []int{1, 2})
+
+	// One, Two and Three were played 5 times between them in training.
+	oneThree := 0.99*0.5 + 0.01*3.0/9
+	fourOne := 0.01 * 3.0 / 9
+	want := Evaluation{
+		Transitions:   2,
+		LogLikelihood: math.Log(oneThree) + math.Log(fourOne),
+		Perplexity:    math.Exp(-(math.Log(oneThree) + math.Log(fourOne)) / 2),
+		// Two and Three are as likely after One, so Three is one of the
+		// most likely.
+		HitRate:  map[int]float64{1: 0.5, 2: 0.5},
+		Coverage: 0.5,
+	}
+	if eval.Transitions != want.Transitions || !closeTo(eval.LogLikelihood, want.LogLikelihood) ||
+		!closeTo(eval.Perplexity, want.Perplexity) || !reflect.DeepEqual(eval.HitRate, want.HitRate) ||
+		eval.Coverage != want.Coverage {
+		t.Errorf("got %+v, want %+v", eval, want)
+	}
+}
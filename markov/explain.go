@@ -0,0 +1,52 @@
+package markov
+
+import (
+	"time"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// maxExamples is how many times a suffix was played after a prefix
+// are kept to show why it was picked.
+const maxExamples = 3
+
+// Pick is a song in a generated playlist along with how it was picked.
+type Pick struct {
+	lastFm.Song
+	// Fallback is how the song was found when the chain had nothing to
+	// follow the playlist with, or empty if it came from the chain.
+	Fallback string `json:",omitempty"`
+	// Prefix is the songs the song was picked to follow, oldest first.
+	Prefix []lastFm.BaseSong `json:",omitempty"`
+	// Backtracked is true if the prefix doesn't end at the song right before
+	// this one, because nothing after it could be picked.
+	Backtracked bool `json:",omitempty"`
+	// Count is the number of times the song was played after the prefix.
+	Count int `json:",omitempty"`
+	// Probability is the chance of the song following the prefix in the chain,
+	// before the temperature and constraints are applied.
+	Probability float64 `json:",omitempty"`
+	// Examples are some of the latest times the song was played after the prefix.
+	Examples []time.Time `json:",omitempty"`
+}
+
+// explain creates the pick for a song picked as the suffix of prefix,
+// which is part of the context the next song was being picked for.
+func explain(song lastFm.Song, prefix []lastFm.Song, context []lastFm.Song, suffix Suffix, suffixes Suffixes, chain Chain) Pick {
+	pick := Pick{
+		Song:     song,
+		Prefix:   make([]lastFm.BaseSong, len(prefix)),
+		Count:    suffix.Frequency,
+		Examples: suffix.Examples,
+	}
+	for i, s := range prefix {
+		pick.Prefix[i] = lastFm.BaseSong{Artist: s.Artist, Title: s.Title}
+	}
+	last := prefix[len(prefix)-1]
+	end := context[len(context)-1]
+	pick.Backtracked = chain.key(last) != chain.key(end)
+	if suffixes.Weight > 0 {
+		pick.Probability = suffix.Weight / suffixes.Weight
+	}
+	return pick
+}
@@ -0,0 +1,57 @@
+package markov
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+func TestGenerateSongListExplains(t *testing.T) {
+	history := playHistory([]string{
+		"A - One", "A - Two", "", "A - One", "A - Two", "", "A - One", "A - Three",
+		"", "A - One", "A - Two", "", "A - One", "A - Two", "", "A - One", "A - Two",
+	})
+	chain := BuildChain(history, Settings{})
+	seed := lastFm.Song{Artist: "A", Title: "One"}
+	want := map[string]struct {
+		count       int
+		probability float64
+		examples    int
+	}{
+		"Two":   {5, 5.0 / 6, maxExamples},
+		"Three": {1, 1.0 / 6, 1},
+	}
+	seen := make(map[string]bool)
+	for n := int64(1); n <= 20; n++ {
+		picks, err := GenerateSongList(2, Constraints{}, seed, chain, 0, rand.New(rand.NewSource(n)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if picks[0].Prefix != nil || picks[0].Count != 0 {
+			t.Errorf("the seed got explained: %+v", picks[0])
+		}
+		pick := picks[1]
+		w := want[pick.Title]
+		seen[pick.Title] = true
+		if pick.Count != w.count || !closeTo(pick.Probability, w.probability) || len(pick.Examples) != w.examples {
+			t.Errorf("%s got a count of %d, probability of %v and %d examples, want %d, %v and %d",
+				pick.Title, pick.Count, pick.Probability, len(pick.Examples), w.count, w.probability, w.examples)
+		}
+		if !reflect.DeepEqual(pick.Prefix, []lastFm.BaseSong{{Artist: "A", Title: "One"}}) || pick.Backtracked {
+			t.Errorf("%s got the prefix %v (backtracked %v), want One", pick.Title, pick.Prefix, pick.Backtracked)
+		}
+		for i := 1; i < len(pick.Examples); i++ {
+			if !pick.Examples[i-1].Before(pick.Examples[i]) {
+				t.Errorf("%s got examples %v, want them oldest first", pick.Title, pick.Examples)
+			}
+		}
+		if pick.Title == "Two" && !pick.Examples[maxExamples-1].Equal(history[len(history)-1].Timestamp) {
+			t.Errorf("Two got examples %v, want the latest ones", pick.Examples)
+		}
+	}
+	if len(seen) != 2 {
+		t.Errorf("only got %v after 20 seeds", seen)
+	}
+}
@@ -0,0 +1,64 @@
+package markov
+
+import (
+	"sort"
+	"time"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// Extend adds the songs in history played after the newest song in the chain,
+// so a chain doesn't have to be built again from the whole history when a few
+// more songs are played. Songs played at or before the newest one are ignored,
+// so the whole history can be passed in. The chain must have been made by
+// BuildChain or LoadChain.
+// The new transitions are counted the same way BuildChain would, and if the chain
+// has a half-life the transitions already in it decay by how much newer the
+// newest song is. Returns the keys of the prefixes the new transitions were
+// added to, which have to be put back in the store if the chain came from one.
+//
+// Extend changes the chain in place, so it can't be used while the chain is
+// being generated from.
+func (c *Chain) Extend(history []lastFm.Song) []string {
+	var songs []lastFm.Song
+	for _, song := range history {
+		if song.Timestamp.After(c.newest) {
+			songs = append(songs, song)
+		}
+	}
+	if len(songs) == 0 {
+		return nil
+	}
+	sort.SliceStable(songs, func(i, j int) bool {
+		return songs[i].Timestamp.Before(songs[j].Timestamp)
+	})
+
+	rescale := c.settings.HalfLife > 0 && !c.newest.IsZero()
+	if rescale {
+		c.decayAll(songs[len(songs)-1].Timestamp)
+	}
+	changed := c.addHistory(songs, nil)
+	reindex := changed
+	if rescale {
+		// The totals of every prefix in memory changed.
+		reindex = make([]string, 0, len(c.Prefixes))
+		for key := range c.Prefixes {
+			reindex = append(reindex, key)
+		}
+	}
+	c.indexPrefixes(reindex)
+	c.indexSongs()
+	return changed
+}
+
+// decayAll scales down the weight of every transition in the chain by how long
+// it's been from the newest song in the chain to newest, so they're weighted
+// the same as if the chain was built when newest was played.
+// Prefixes in the store are scaled as they're fetched again.
+func (c *Chain) decayAll(newest time.Time) {
+	factor := c.settings.decay(c.newest, newest)
+	for key, suffixes := range c.Prefixes {
+		c.Prefixes[key] = suffixes.scale(factor)
+	}
+	c.forgetFetched()
+}
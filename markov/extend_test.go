@@ -0,0 +1,75 @@
+package markov
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+func TestExtendMatchesBuildChain(t *testing.T) {
+	history := testHistory()
+	// Move every third song up to a minute after the one before it,
+	// so the one before it was skipped.
+	for i := 1; i < len(history); i += 3 {
+		if history[i].Timestamp.Sub(history[i-1].Timestamp) < time.Hour {
+			history[i].Timestamp = history[i-1].Timestamp.Add(time.Minute)
+		}
+	}
+	seed := lastFm.Song{Artist: "Beach House", Title: "Myth"}
+	for _, settings := range []Settings{
+		{Order: 1},
+		{Order: 3},
+		{Order: 2, SkipGap: 2 * time.Minute},
+		{Order: 2, SkipGap: 2 * time.Minute, PenalizeSkips: true},
+		{Order: 2, HalfLife: 24 * time.Hour},
+		{Order: 2, Reverse: true},
+	} {
+		built := BuildChain(history, settings)
+		extended := BuildChain(history[:5], settings)
+		extended.Extend(history[5:12])
+		// Songs it already has are ignored.
+		extended.Extend(history)
+
+		if len(built.Prefixes) != len(extended.Prefixes) {
+			t.Errorf("%+v: got %d prefixes, want %d", settings, len(extended.Prefixes), len(built.Prefixes))
+		}
+		for key, suffixes := range built.Prefixes {
+			if !sameSuffixes(suffixes, extended.Prefixes[key]) {
+				t.Errorf("%+v: prefix %q got %+v, want %+v", settings, key, extended.Prefixes[key], suffixes)
+			}
+		}
+		if settings.Reverse {
+			continue
+		}
+		want, _ := GenerateSongList(7, Constraints{}, seed, built, 0, rand.New(rand.NewSource(1)))
+		got, _ := GenerateSongList(7, Constraints{}, seed, extended, 0, rand.New(rand.NewSource(1)))
+		if !reflect.DeepEqual(titles(got), titles(want)) {
+			t.Errorf("%+v: got %v, want %v", settings, titles(got), titles(want))
+		}
+	}
+}
+
+// sameSuffixes reports whether two prefixes have the same suffixes,
+// counts and weights, allowing for rounding in the weights.
+func sameSuffixes(a Suffixes, b Suffixes) bool {
+	if a.Total != b.Total || !closeTo(a.Weight, b.Weight) || len(a.Suffixes) != len(b.Suffixes) {
+		return false
+	}
+	for i := range a.Suffixes {
+		x, y := a.Suffixes[i], b.Suffixes[i]
+		if x.Name != y.Name || x.Artist != y.Artist || x.Frequency != y.Frequency ||
+			!closeTo(x.Weight, y.Weight) || !reflect.DeepEqual(x.Examples, y.Examples) {
+			return false
+		}
+	}
+	return true
+}
+
+// closeTo reports whether two numbers are the same but for rounding.
+func closeTo(a float64, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*(1+math.Abs(a))
+}
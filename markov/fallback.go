@@ -0,0 +1,106 @@
+package markov
+
+import (
+	"math/rand"
+	"sort"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// Fallbacks used when the chain has nothing to follow a playlist with,
+// in the order they're tried.
+const (
+	FallbackSameArtist = "sameArtist"
+	FallbackPopular    = "popular"
+	FallbackRandom     = "random"
+)
+
+// popularCount is how many of the most played songs FallbackPopular picks from.
+const popularCount = 50
+
+// artistLookback is how many of the latest songs in a playlist
+// FallbackSameArtist tries the artists of.
+const artistLookback = 3
+
+// indexSongs sorts the songs in the chain by how many times they were played,
+// both overall and for each artist, for the fallbacks to pick from.
+// The stationary distribution keeps any scores it already has, with the
+// songs sorted by them again the next time it's needed.
+func (c *Chain) indexSongs() {
+	stationary := &stationaryCache{}
+	if c.stationary != nil {
+		stationary.scores = c.stationary.scores
+	}
+	c.stationary = stationary
+	c.popular = make([]string, 0, len(c.Plays))
+	for key := range c.Plays {
+		c.popular = append(c.popular, key)
+	}
+	sortByPlays(c.popular, c.Plays)
+	c.byArtist = make(map[string][]string)
+	for _, key := range c.popular {
+		artist := lookupArtist(c.artists, c.settings.Canonical, c.Songs[key].Artist)
+		c.byArtist[artist] = append(c.byArtist[artist], key)
+	}
+}
+
+// sortByPlays sorts song keys by how many times each was played, most first.
+// Songs played the same number of times are sorted by key so the order
+// is always the same.
+func sortByPlays(keys []string, plays map[string]int) {
+	sort.Slice(keys, func(i, j int) bool {
+		if plays[keys[i]] != plays[keys[j]] {
+			return plays[keys[i]] > plays[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+}
+
+// pickFallback finds a song to jump to when the chain has no suffix for the end
+// of list. It first tries songs by the artists of the latest songs in list,
+// then the user's most played songs, then any song in their history.
+// Each is picked at random weighted by how many times it was played, and has
+// to be allowed to be added to list (see Constraints.allowed).
+// Returns the song along with the fallback that found it, and false if nothing is allowed.
+func pickFallback(list []lastFm.Song, constraints Constraints, chain Chain, r *rand.Rand) (Pick, bool) {
+	for j := len(list) - 1; j >= 0 && j >= len(list)-artistLookback; j-- {
+		keys := chain.byArtist[lookupArtist(chain.artists, chain.settings.Canonical, list[j].Artist)]
+		if song, found := pickByPlays(keys, list, constraints, chain, r); found {
+			return Pick{Song: song, Fallback: FallbackSameArtist}, true
+		}
+	}
+	popular := chain.popular
+	if len(popular) > popularCount {
+		popular = popular[:popularCount]
+	}
+	if song, found := pickByPlays(popular, list, constraints, chain, r); found {
+		return Pick{Song: song, Fallback: FallbackPopular}, true
+	}
+	if song, found := pickByPlays(chain.popular, list, constraints, chain, r); found {
+		return Pick{Song: song, Fallback: FallbackRandom}, true
+	}
+	return Pick{}, false
+}
+
+// pickByPlays randomly picks one of the songs with the given keys that's allowed
+// to be added to list, weighted by how many times each was played.
+// Returns false if it can't find one.
+func pickByPlays(keys []string, list []lastFm.Song, constraints Constraints, chain Chain, r *rand.Rand) (lastFm.Song, bool) {
+	if len(keys) == 0 {
+		return lastFm.Song{}, false
+	}
+	cdf := make(CDF, 0, len(keys))
+	total := 0.0
+	for j, key := range keys {
+		total += float64(chain.Plays[key])
+		cdf = append(cdf, CDFPoint{Total: total, Index: j})
+	}
+	for attempts := 0; attempts < maxAttempts; attempts++ {
+		display := chain.Songs[keys[searchCDF(cdf, r)]]
+		song := lastFm.Song{Artist: display.Artist, Title: display.Title}
+		if constraints.allowed(list, song) {
+			return song, true
+		}
+	}
+	return lastFm.Song{}, false
+}
@@ -0,0 +1,65 @@
+package markov
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+func TestGenerateSongListFallbacks(t *testing.T) {
+	chain := BuildChain(playHistory([]string{
+		"A - One", "A - Two",
+		"",
+		"A - Three",
+		"",
+		"B - Four", "B - Five", "B - Four", "B - Five",
+	}), Settings{})
+	seed := lastFm.Song{Artist: "A", Title: "One"}
+	picks, err := GenerateSongList(5, Constraints{}, seed, chain, 0, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		title    string
+		fallback string
+	}{
+		{"One", ""},
+		{"Two", ""},
+		// Nothing follows Two, so another song by A is next.
+		{"Three", FallbackSameArtist},
+		// There's nothing left by A.
+		{"", FallbackPopular},
+		{"", ""},
+	}
+	if len(picks) != len(want) {
+		t.Fatalf("got %q, want %d songs", titles(picks), len(want))
+	}
+	for i, w := range want {
+		if (w.title != "" && picks[i].Title != w.title) || picks[i].Fallback != w.fallback {
+			t.Errorf("song %d got %q from %q, want %q from %q", i, picks[i].Title, picks[i].Fallback, w.title, w.fallback)
+		}
+	}
+
+	// Only running out of songs ends a playlist early.
+	picks, err = GenerateSongList(10, Constraints{}, seed, chain, 0, rand.New(rand.NewSource(1)))
+	if err == nil || len(picks) != len(chain.Songs) {
+		t.Errorf("got %q and %v, want every song and an error", titles(picks), err)
+	}
+}
+
+func TestPickFallbackRandom(t *testing.T) {
+	plays := make([]string, 0, 2*popularCount+2)
+	for i := 0; i < popularCount+1; i++ {
+		// Played twice each, in sessions of their own.
+		title := string(rune('a'+i%26)) + string(rune('a'+i/26))
+		plays = append(plays, "A - "+title, "", "A - "+title, "")
+	}
+	plays = append(plays, "B - Least played")
+	chain := BuildChain(playHistory(plays), Settings{})
+	constraints := Constraints{ExcludeArtists: []string{"A"}}.prepare(chain)
+	pick, found := pickFallback(nil, constraints, chain, rand.New(rand.NewSource(1)))
+	if !found || pick.Title != "Least played" || pick.Fallback != FallbackRandom {
+		t.Errorf("got %q from %q, want the only song left from %q", pick.Title, pick.Fallback, FallbackRandom)
+	}
+}
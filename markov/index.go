@@ -0,0 +1,172 @@
+package markov
+
+import (
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/snyderks/spotkov-web/canonical"
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// titleKey is a single-song prefix in the title index.
+type titleKey struct {
+	title string // title part of the key
+	key   string
+}
+
+// index works out the totals and distributions of every prefix's suffixes
+// and indexes the songs in the chain, so songs can be looked up and
+// suffixes picked without going through the whole chain.
+// The way each song is displayed is indexed as it's added (see indexSong).
+func (c *Chain) index() {
+	c.titles = nil
+	prefixes := make([]string, 0, len(c.Prefixes))
+	for key := range c.Prefixes {
+		prefixes = append(prefixes, key)
+	}
+	c.indexPrefixes(prefixes)
+	c.indexSongs()
+}
+
+// indexPrefixes works out the totals and distributions of the suffixes of
+// the prefixes with the given keys, adding any single-song prefixes that
+// aren't in the title index yet.
+func (c *Chain) indexPrefixes(prefixes []string) {
+	// Anything added goes in a new array, so copies of the chain
+	// don't see the titles move around.
+	indexed := c.titles[:len(c.titles):len(c.titles)]
+	c.titles = indexed
+	for _, key := range prefixes {
+		c.Prefixes[key] = c.Prefixes[key].total()
+		if strings.Contains(key, prefixSeparator) {
+			continue
+		}
+		title := titleKey{title: titleOf(key), key: key}
+		i := sort.Search(len(indexed), func(i int) bool {
+			return !titleBefore(indexed[i], title)
+		})
+		if i == len(indexed) || indexed[i] != title {
+			c.titles = append(c.titles, title)
+		}
+	}
+	if len(c.titles) > len(indexed) {
+		sort.Slice(c.titles, func(i, j int) bool {
+			return titleBefore(c.titles[i], c.titles[j])
+		})
+	}
+}
+
+// titleOf is the title part of a song key.
+func titleOf(key string) string {
+	return key[strings.Index(key, artistSeparator)+len(artistSeparator):]
+}
+
+// isStart reports whether the song with the given key is a single-song
+// prefix in the chain, so a playlist can be started from it.
+func (c Chain) isStart(key string) bool {
+	title := titleKey{title: titleOf(key), key: key}
+	i := sort.Search(len(c.titles), func(i int) bool {
+		return !titleBefore(c.titles[i], title)
+	})
+	return i < len(c.titles) && c.titles[i] == title
+}
+
+// titleBefore reports whether a comes before b in the title index.
+func titleBefore(a titleKey, b titleKey) bool {
+	if a.title != b.title {
+		return a.title < b.title
+	}
+	return a.key < b.key
+}
+
+// indexSong adds a way a song is displayed to the index.
+func (c *Chain) indexSong(song lastFm.BaseSong, key string) {
+	if c.keys == nil {
+		c.keys = make(map[lastFm.BaseSong]string)
+		c.artists = make(map[string]string)
+	}
+	if _, exists := c.keys[song]; !exists {
+		c.keys[song] = key
+		c.indexArtist(song.Artist)
+	}
+}
+
+// indexArtist adds how an artist is compared to the index.
+func (c *Chain) indexArtist(artist string) {
+	if _, exists := c.artists[artist]; !exists {
+		c.artists[artist] = compareArtist(c.settings.Canonical, artist)
+	}
+}
+
+// key finds the song key for a song. Songs displayed the way they
+// are in the chain are looked up in the index instead of being worked out.
+func (c Chain) key(song lastFm.Song) string {
+	return lookupKey(c.keys, c.settings.Canonical, song)
+}
+
+// prefixKey creates the chain key for a prefix of songs.
+func (c Chain) prefixKey(songs []lastFm.Song) string {
+	if len(songs) == 1 {
+		return c.key(songs[0])
+	}
+	keys := make([]string, len(songs))
+	for i, song := range songs {
+		keys[i] = c.key(song)
+	}
+	return strings.Join(keys, prefixSeparator)
+}
+
+// lookupKey finds the song key for a song in an index of keys,
+// working it out with rules if the song isn't in the index.
+func lookupKey(keys map[lastFm.BaseSong]string, rules canonical.Rules, song lastFm.Song) string {
+	if key, exists := keys[lastFm.BaseSong{Artist: song.Artist, Title: song.Title}]; exists {
+		return key
+	}
+	return songKey(rules, song.Artist, song.Title)
+}
+
+// lookupArtist finds how an artist is compared in an index of artists,
+// working it out with rules if the artist isn't in the index.
+func lookupArtist(artists map[string]string, rules canonical.Rules, artist string) string {
+	if fmtArtist, exists := artists[artist]; exists {
+		return fmtArtist
+	}
+	return compareArtist(rules, artist)
+}
+
+// sampler picks suffixes at one temperature using r. The chain has the
+// distributions for a temperature of 1, and the sampler keeps the ones it
+// builds for any other temperature so each is only built once.
+type sampler struct {
+	temperature float64
+	r           *rand.Rand
+	tables      map[string]CDF // distribution for each prefix key
+}
+
+// newSampler creates a sampler for the given temperature (see clampTemperature).
+func newSampler(temperature float64, r *rand.Rand) *sampler {
+	return &sampler{
+		temperature: clampTemperature(temperature),
+		r:           r,
+		tables:      make(map[string]CDF),
+	}
+}
+
+// pick randomly picks one of the suffixes of the prefix with the given key,
+// in proportion to their weights scaled by the temperature.
+func (s *sampler) pick(key string, suffixes Suffixes) Suffix {
+	if len(suffixes.Suffixes) == 1 { // there's only one choice.
+		return suffixes.Suffixes[0]
+	}
+	cdf := suffixes.cdf
+	if s.temperature != 1 || cdf == nil {
+		var exists bool
+		cdf, exists = s.tables[key]
+		if !exists {
+			cdf = buildCDF(suffixes.Suffixes, s.temperature)
+			s.tables[key] = cdf
+		}
+	}
+	return suffixes.Suffixes[searchCDF(cdf, s.r)]
+}
@@ -0,0 +1,128 @@
+package markov
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/snyderks/spotkov/lastFm"
+	"github.com/snyderks/spotkov/tools"
+)
+
+// Size of the made up history the benchmarks generate from.
+const (
+	benchmarkSongs   = 10000
+	benchmarkPlays   = 100000
+	benchmarkArtists = 1000
+	benchmarkLength  = 200
+)
+
+var (
+	benchmarkOnce    sync.Once
+	benchmarkHistory []lastFm.Song
+	benchmarkChain   Chain
+)
+
+// benchmarkSetup builds the chain the benchmarks generate from, once.
+func benchmarkSetup(b *testing.B) {
+	benchmarkOnce.Do(func() {
+		benchmarkHistory = makeHistory(benchmarkSongs, benchmarkPlays, benchmarkArtists)
+		benchmarkChain = BuildChain(benchmarkHistory, Settings{Order: 1})
+	})
+	b.ResetTimer()
+}
+
+// makeHistory makes up a listening history where a few songs are played far
+// more than the rest, the way they are in real histories, and each song tends
+// to be followed by one of a small set of others.
+func makeHistory(songs int, plays int, artists int) []lastFm.Song {
+	r := rand.New(rand.NewSource(0))
+	zipf := rand.NewZipf(r, 1.1, 1, uint64(songs-1))
+	history := make([]lastFm.Song, plays)
+	t := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	n := int(zipf.Uint64())
+	for i := range history {
+		history[i] = lastFm.Song{
+			Artist:    "Artist " + strconv.Itoa(n%artists),
+			Title:     "Song " + strconv.Itoa(n),
+			Timestamp: t,
+		}
+		t = t.Add(time.Duration(150+r.Intn(150)) * time.Second)
+		if r.Intn(4) == 0 {
+			n = int(zipf.Uint64())
+		} else {
+			n = (n*7 + r.Intn(5)) % songs
+		}
+	}
+	return history
+}
+
+func BenchmarkBuildChain(b *testing.B) {
+	benchmarkSetup(b)
+	for i := 0; i < b.N; i++ {
+		BuildChain(benchmarkHistory, Settings{Order: 1})
+	}
+}
+
+func BenchmarkGenerateSongList(b *testing.B) {
+	benchmarkSetup(b)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < b.N; i++ {
+		seed := benchmarkHistory[r.Intn(len(benchmarkHistory))]
+		GenerateSongList(benchmarkLength, Constraints{}, seed, benchmarkChain, 0, r)
+	}
+}
+
+// BenchmarkGenerateSongListLinearScan is the baseline the index is compared to.
+// It generates the same length of playlist the way the chain used to be
+// walked, without the index or the prebuilt distributions.
+func BenchmarkGenerateSongListLinearScan(b *testing.B) {
+	benchmarkSetup(b)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < b.N; i++ {
+		song := benchmarkHistory[r.Intn(len(benchmarkHistory))]
+		for j := 1; j < benchmarkLength; j++ {
+			next, found := linearScanSuffix(benchmarkChain, song, r)
+			if !found {
+				break
+			}
+			song = next
+		}
+	}
+}
+
+// linearScanSuffix picks the song to follow song the way selectSuffix did
+// before the chain was indexed. It goes through every key in the chain,
+// normalizing each one to find the prefix, then sorts and totals the
+// suffixes' frequencies to pick one.
+func linearScanSuffix(chain Chain, song lastFm.Song, r *rand.Rand) (lastFm.Song, bool) {
+	prefix := tools.LowerAndStripNonAlphaNumeric(song.Artist + artistSeparator + song.Title)
+	for key, suffixes := range chain.Prefixes {
+		fmtKey := tools.LowerAndStripNonAlphaNumeric(key)
+		if fmtKey != prefix && !strings.HasPrefix(fmtKey, prefix) {
+			continue
+		}
+		cdf := make(CDF, 0, len(suffixes.Suffixes))
+		for j, suffix := range suffixes.Suffixes {
+			if suffix.Frequency > 0 {
+				cdf = append(cdf, CDFPoint{Total: float64(suffix.Frequency), Index: j})
+			}
+		}
+		if len(cdf) == 0 {
+			return lastFm.Song{}, false
+		}
+		sort.Slice(cdf, func(i, j int) bool {
+			return cdf[i].Total < cdf[j].Total
+		})
+		for j := 1; j < len(cdf); j++ {
+			cdf[j].Total += cdf[j-1].Total
+		}
+		suffix := suffixes.Suffixes[searchCDF(cdf, r)]
+		return lastFm.Song{Artist: suffix.Artist, Title: suffix.Name}, true
+	}
+	return lastFm.Song{}, false
+}
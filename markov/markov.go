@@ -0,0 +1,681 @@
+// Package markov takes the tracks played and creates a Markov chain
+// to generate playlists from.
+package markov
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/snyderks/spotkov-web/canonical"
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// Chain holds the suffixes for every prefix of one up to Order songs,
+// so generation can use the longest context it has and back off to
+// shorter ones.
+// Songs are identified by both their artist and title, so that songs
+// with the same name by different artists stay separate.
+type Chain struct {
+	Order    int
+	Prefixes map[string]Suffixes
+	Songs    map[string]lastFm.BaseSong // how each song key is displayed
+	Plays    map[string]int             // how many times each song was played
+	// LastPlayed is when each song was last played.
+	LastPlayed map[string]time.Time
+
+	popular  []string            // song keys, most played first
+	byArtist map[string][]string // song keys for each artist, most played first
+
+	keys    map[lastFm.BaseSong]string // song key for each way a song is displayed
+	artists map[string]string          // artist as displayed to how it's compared
+	titles  []titleKey                 // single-song prefixes, sorted by title
+
+	settings Settings      // what the chain was built with
+	newest   time.Time     // when the newest song in the chain was played
+	tail     []lastFm.Song // the end of the history, to extend the chain from
+
+	store   NodeStore  // where prefixes not in Prefixes are, if anywhere
+	fetched *nodeCache // prefixes fetched from the store
+
+	stationary *stationaryCache // see StationaryDistribution
+}
+
+// Suffixes holds all suffixes for a specific prefix
+type Suffixes struct {
+	Suffixes []Suffix
+	Total    int     // total of the Frequencies above zero
+	Weight   float64 // total of the Weights above zero
+
+	cdf CDF // distribution to pick suffixes from at a temperature of 1
+}
+
+// Suffix holds a song that occurs after another song.
+// Multiple suffixes with the same name can be duplicated
+// across multiple source songs.
+type Suffix struct {
+	Name      string
+	Artist    string // for more accurate lookup in Spotify
+	Frequency int    // number of times the suffix happens, less any skips counted against it
+	// Weight is the Frequency with each occurrence scaled down by how long
+	// ago it happened. Suffixes are picked in proportion to it.
+	Weight float64
+	// Examples are the latest times the suffix was played after the prefix,
+	// oldest first, up to maxExamples of them.
+	Examples []time.Time
+}
+
+// CDF is a structure for a continuous distribution function,
+// generated from the chain.
+type CDF []CDFPoint
+
+// CDFPoint is a single option in a CDF. Total is the sum of the weights
+// of this and every previous option, and Index points to the option.
+type CDFPoint struct {
+	Total float64
+	Index int
+}
+
+const maxAttempts = 200
+
+// MaxOrder is the longest prefix, in songs, that a chain can be built with.
+const MaxOrder = 4
+
+// MinTemperature and MaxTemperature bound how much suffix sampling
+// can be sharpened or flattened.
+const (
+	MinTemperature = 0.1
+	MaxTemperature = 10
+)
+
+// prefixSeparator joins the songs of a multi-song prefix into one key.
+const prefixSeparator = "\x1f"
+
+// artistSeparator splits the artist and title in a song key.
+const artistSeparator = "\x1e"
+
+// songKey creates the identity of a song in the chain.
+// The artist and title are canonicalized with rules, and case and punctuation
+// are ignored, so the different ways Last.FM reports a song don't split it in two.
+func songKey(rules canonical.Rules, artist string, title string) string {
+	return compareArtist(rules, artist) + artistSeparator + compareTitle(rules, title)
+}
+
+// compareArtist is how an artist is compared in the chain (see songKey).
+func compareArtist(rules canonical.Rules, artist string) string {
+	return canonical.Key(rules.Artist(artist))
+}
+
+// compareTitle is how a title is compared in the chain (see songKey).
+func compareTitle(rules canonical.Rules, title string) string {
+	return canonical.Key(rules.Title(title))
+}
+
+// Settings control how a chain is built from the play history.
+// The zero value of each field uses its default.
+type Settings struct {
+	Order      int           // number of previous songs (1 to MaxOrder) that make up a prefix
+	SessionGap time.Duration // longest gap between two songs played in one sitting
+	SkipGap    time.Duration // songs followed by another this quickly were skipped
+	// PenalizeSkips counts a transition to a skipped song against it,
+	// instead of leaving it out.
+	PenalizeSkips bool
+	// HalfLife is how long it takes for a transition to count half as
+	// much as one from the most recent song played. Zero turns off decay.
+	HalfLife time.Duration
+	// Hours and Weekdays limit the chain to transitions made at those times
+	// in Location. Each is a bit mask (see HourMask and WeekdayMask)
+	// where zero allows any time.
+	Hours    uint32
+	Weekdays uint8
+	Location *time.Location // defaults to UTC
+	// Reverse builds the chain backwards, with each song following the songs
+	// played after it, so playlists can be generated to end on a song
+	// (see GenerateEndingSongList).
+	Reverse bool
+	// Canonical are the rules for which ways of reporting a song are the
+	// same song. Songs are displayed the first way they were played.
+	Canonical canonical.Rules
+}
+
+// HourMask creates a mask for Settings.Hours from hours of the day (0 to 23).
+func HourMask(hours ...int) uint32 {
+	var mask uint32
+	for _, hour := range hours {
+		if hour >= 0 && hour < 24 {
+			mask |= 1 << uint(hour)
+		}
+	}
+	return mask
+}
+
+// WeekdayMask creates a mask for Settings.Weekdays from days of the week.
+func WeekdayMask(days ...time.Weekday) uint8 {
+	var mask uint8
+	for _, day := range days {
+		if day >= time.Sunday && day <= time.Saturday {
+			mask |= 1 << uint(day)
+		}
+	}
+	return mask
+}
+
+// DefaultSessionGap is the session gap used when the settings don't have one.
+const DefaultSessionGap = time.Hour
+
+// withDefaults fills in anything that wasn't set and clamps the order.
+func (s Settings) withDefaults() Settings {
+	if s.Order < 1 {
+		s.Order = 1
+	}
+	if s.Order > MaxOrder {
+		s.Order = MaxOrder
+	}
+	if s.SessionGap <= 0 {
+		s.SessionGap = DefaultSessionGap
+	}
+	if s.Location == nil {
+		s.Location = time.UTC
+	}
+	return s
+}
+
+// inContext reports whether t falls in the hours and weekdays of the settings.
+func (s Settings) inContext(t time.Time) bool {
+	local := t.In(s.Location)
+	if s.Hours != 0 && s.Hours&(1<<uint(local.Hour())) == 0 {
+		return false
+	}
+	if s.Weekdays != 0 && s.Weekdays&(1<<uint(local.Weekday())) == 0 {
+		return false
+	}
+	return true
+}
+
+// gap is the time between two songs, whichever was played first.
+func gap(a lastFm.Song, b lastFm.Song) time.Duration {
+	split := b.Timestamp.Sub(a.Timestamp)
+	if split < 0 {
+		return -split
+	}
+	return split
+}
+
+// sameSession reports whether consecutive songs were played
+// within the session gap of each other.
+func (s Settings) sameSession(songs []lastFm.Song) bool {
+	for i := 0; i < len(songs)-1; i++ {
+		if gap(songs[i], songs[i+1]) >= s.SessionGap {
+			return false
+		}
+	}
+	return true
+}
+
+// minDecay keeps the oldest transitions from decaying all the way to zero,
+// so songs only played long ago can still be picked if there's nothing else.
+const minDecay = 1e-300
+
+// decay is how much a transition made at t counts, compared to one made at
+// the newest time. It halves every half-life.
+func (s Settings) decay(t time.Time, newest time.Time) float64 {
+	if s.HalfLife <= 0 {
+		return 1
+	}
+	age := newest.Sub(t)
+	if age < 0 {
+		age = 0
+	}
+	return math.Max(minDecay, math.Exp2(-float64(age)/float64(s.HalfLife)))
+}
+
+// skipped reports whether the song at index i was skipped, meaning the next
+// song was played less than the skip gap after it.
+// The last song played can't be known to be skipped.
+func (s Settings) skipped(songs []lastFm.Song, i int) bool {
+	if s.SkipGap <= 0 || i+1 >= len(songs) {
+		return false
+	}
+	return gap(songs[i], songs[i+1]) < s.SkipGap
+}
+
+// BuildChain determines what songs are played after others and creates a
+// chain to then randomly select from.
+// Takes an array of songs, oldest first, and the settings to build with.
+// Only transitions within one session are counted, and transitions to songs
+// that were skipped are either left out or counted against the song.
+// The last song can't be known to be skipped yet, so with skip detection on
+// the transition to it is left out until the chain is extended (see Extend).
+// Transitions are weighted by how recent they are compared to the newest song
+// if the settings have a half-life, and only transitions made at the hours and
+// weekdays in the settings are counted.
+func BuildChain(songs []lastFm.Song, settings Settings) Chain {
+	settings = settings.withDefaults()
+	chain := Chain{
+		Order:    settings.Order,
+		Prefixes: make(map[string]Suffixes, len(songs)),
+		Songs:    make(map[string]lastFm.BaseSong),
+		Plays:    make(map[string]int),
+
+		LastPlayed: make(map[string]time.Time),
+		settings:   settings,
+	}
+	chain.addHistory(songs, make(map[string]int))
+	chain.index()
+	return chain
+}
+
+// addHistory adds songs played after the ones the chain already has, oldest
+// first, along with the transitions to them from the end of the chain's history.
+// positions is where each suffix is in its prefix's list, keyed by the prefix and
+// song keys, so adding to it doesn't have to search the list. If it's nil the
+// lists are searched instead.
+// Returns the keys of the prefixes that were added to.
+func (c *Chain) addHistory(songs []lastFm.Song, positions map[string]int) []string {
+	settings := c.settings
+	history := make([]lastFm.Song, 0, len(c.tail)+len(songs))
+	history = append(append(history, c.tail...), songs...)
+	keys := make([]string, len(history))
+	for i, song := range history {
+		key := c.key(song)
+		keys[i] = key
+		if i < len(c.tail) {
+			continue
+		}
+		if _, exists := c.Songs[key]; !exists {
+			c.Songs[key] = lastFm.BaseSong{Artist: song.Artist, Title: song.Title}
+		}
+		c.indexSong(lastFm.BaseSong{Artist: song.Artist, Title: song.Title}, key)
+		c.Plays[key]++
+		if song.Timestamp.After(c.LastPlayed[key]) {
+			c.LastPlayed[key] = song.Timestamp
+		}
+		if song.Timestamp.After(c.newest) {
+			c.newest = song.Timestamp
+		}
+	}
+	touched := make(map[string]bool)
+	if settings.Reverse {
+		c.addReverseTransitions(history, keys, positions, touched)
+	} else {
+		c.addTransitions(history, keys, positions, touched)
+	}
+	// Keep enough of the end of the history to add the
+	// transitions to the next songs.
+	tail := len(history) - (settings.Order + 1)
+	if tail < 0 {
+		tail = 0
+	}
+	c.tail = append([]lastFm.Song(nil), history[tail:]...)
+
+	prefixes := make([]string, 0, len(touched))
+	for key := range touched {
+		prefixes = append(prefixes, key)
+	}
+	return prefixes
+}
+
+// addTransitions adds the transitions to the songs in history after the
+// chain's tail, under every prefix of up to Order songs ending right before them
+// (see addHistory).
+func (c *Chain) addTransitions(history []lastFm.Song, keys []string, positions map[string]int, touched map[string]bool) {
+	settings := c.settings
+	// Start from the transition to the first new song, or the one before it
+	// if it was held back to see if the song it goes to was skipped.
+	start := len(c.tail) - 1
+	end := len(history) - 1
+	if settings.SkipGap > 0 {
+		start--
+		end--
+	}
+	if start < 0 {
+		start = 0
+	}
+	// Creating suffixes, so the last song played doesn't have any yet.
+	for i := start; i < end; i++ {
+		count, counted := settings.transition(history, keys, i)
+		if !counted {
+			continue
+		}
+		weight := float64(count) * settings.decay(history[i+1].Timestamp, c.newest)
+		// Record the transition under every prefix length ending at song i.
+		for n := 1; n <= settings.Order && i-n+1 >= 0; n++ {
+			prefix := history[i-n+1 : i+1]
+			// Longer prefixes only make sense if they were played
+			// together in one sitting.
+			if n > 1 && !settings.sameSession(prefix[:2]) {
+				break
+			}
+			key := strings.Join(keys[i-n+1:i+1], prefixSeparator)
+			c.record(key, keys[i+1], history[i+1], count, weight, positions, touched)
+		}
+	}
+}
+
+// addReverseTransitions adds the transitions in history to a reverse chain,
+// where each song is a suffix of the songs played after it, so the chain walks
+// back in time. A transition is recorded under every prefix of up to Order songs
+// starting right after it, listed newest first, once the last song the prefix
+// needs has been played. Transitions are held back one more song with skip
+// detection on, the same as in a forward chain (see addHistory).
+func (c *Chain) addReverseTransitions(history []lastFm.Song, keys []string, positions map[string]int, touched map[string]bool) {
+	settings := c.settings
+	after := 1
+	if settings.SkipGap > 0 {
+		after = 2
+	}
+	prefixKeys := make([]string, settings.Order)
+	// Each new song completes the prefixes that end with it.
+	for j := len(c.tail); j < len(history); j++ {
+		for n := 1; n <= settings.Order; n++ {
+			i := j - n
+			if n < after {
+				i = j - after
+			}
+			if i < 0 {
+				break
+			}
+			prefix := history[i+1 : i+n+1]
+			if n > 1 && !settings.sameSession(prefix) {
+				break
+			}
+			count, counted := settings.transition(history, keys, i)
+			if !counted {
+				continue
+			}
+			weight := float64(count) * settings.decay(history[i+1].Timestamp, c.newest)
+			for k := 0; k < n; k++ {
+				prefixKeys[k] = keys[i+n-k]
+			}
+			key := strings.Join(prefixKeys[:n], prefixSeparator)
+			c.record(key, keys[i], history[i], count, weight, positions, touched)
+		}
+	}
+}
+
+// transition reports whether the transition from the song at index i in history
+// to the next one counts, and how much: 1, or -1 if it counts against a song
+// that was skipped. It doesn't count if the song is the same, the songs weren't
+// played in one session or in the settings' context, or the next song was
+// skipped and skips aren't penalized.
+func (s Settings) transition(history []lastFm.Song, keys []string, i int) (int, bool) {
+	// don't want to add duplicates
+	if keys[i+1] == keys[i] {
+		return 0, false
+	}
+	if !s.sameSession(history[i:i+2]) || !s.inContext(history[i].Timestamp) {
+		return 0, false
+	}
+	if s.skipped(history, i+1) {
+		if !s.PenalizeSkips {
+			return 0, false
+		}
+		return -1, true
+	}
+	return 1, true
+}
+
+// record adds count occurrences of song, with the given song key, to the
+// suffixes of the prefix with the given key (see addHistory).
+func (c *Chain) record(key string, songKey string, song lastFm.Song, count int, weight float64, positions map[string]int, touched map[string]bool) {
+	suffixes := c.own(key)
+	position, exists := c.suffixPosition(suffixes, key, songKey, positions)
+	if !exists {
+		position = len(suffixes.Suffixes)
+		if positions != nil {
+			positions[key+prefixSeparator+songKey] = position
+		}
+		suffixes.Suffixes = append(suffixes.Suffixes, Suffix{Name: song.Title, Artist: song.Artist})
+	}
+	c.Prefixes[key] = suffixes.add(position, song, count, weight)
+	touched[key] = true
+}
+
+// suffixPosition finds where the song with the given key is in the suffixes of
+// the prefix with the given key, using positions if it isn't nil (see addHistory).
+func (c *Chain) suffixPosition(suffixes Suffixes, prefix string, song string, positions map[string]int) (int, bool) {
+	if positions != nil {
+		position, exists := positions[prefix+prefixSeparator+song]
+		return position, exists
+	}
+	for i, suffix := range suffixes.Suffixes {
+		if c.key(lastFm.Song{Artist: suffix.Artist, Title: suffix.Name}) == song {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// add counts count more occurrences of song, the suffix at position i, after
+// the prefix, which together have the given weight. A negative count and weight
+// count against the song. The song's timestamp is kept as an example if it
+// counts towards the song. The totals are worked out once the chain is built
+// (see Suffixes.total).
+func (s Suffixes) add(i int, song lastFm.Song, count int, weight float64) Suffixes {
+	s.Suffixes[i].Frequency += count
+	s.Suffixes[i].Weight += weight
+	if count > 0 {
+		examples := append(s.Suffixes[i].Examples, song.Timestamp)
+		if len(examples) > maxExamples {
+			examples = examples[len(examples)-maxExamples:]
+		}
+		s.Suffixes[i].Examples = examples
+	}
+	return s
+}
+
+// total works out the totals of the suffixes and the distribution to pick them from.
+func (s Suffixes) total() Suffixes {
+	// Suffixes counted against more than they happened can't be picked,
+	// so they don't count towards the totals.
+	s.Total = 0
+	s.Weight = 0
+	for _, suffix := range s.Suffixes {
+		if suffix.Frequency > 0 {
+			s.Total += suffix.Frequency
+		}
+		if suffix.Weight > 0 {
+			s.Weight += suffix.Weight
+		}
+	}
+	s.cdf = buildCDF(s.Suffixes, 1)
+	return s
+}
+
+// GenerateSongList takes a seed song, a chain to select from, a length, and the constraints every song after the seed follows.
+// The next song is picked from the longest prefix at the end of the list that the chain has suffixes for.
+// Suffixes are sampled with the given temperature (see buildCDF), where 0 means the raw frequencies.
+// When nothing in the list leads anywhere, it jumps to another song instead (see pickFallback).
+// All random picks are drawn from r, so the same chain and source state always give the same list.
+// It returns a list of songs and an optional error. The list is only shorter than
+// length if there aren't enough songs in the history to fill it.
+func GenerateSongList(length int, constraints Constraints, startingSong lastFm.Song, chain Chain, temperature float64, r *rand.Rand) ([]Pick, error) {
+	s := newSampler(temperature, r)
+	constraints = constraints.prepare(chain)
+
+	// The seed is typed in by the user, so it might be slightly different in the chain.
+	startingSong, exists := resolveSeed(chain, startingSong)
+	if !exists {
+		return nil, errors.New("The song you entered couldn't be found. Please try again.")
+	}
+
+	var genError error
+	list := make([]lastFm.Song, 0, length)
+	list = append(list, startingSong)
+	picks := make([]Pick, 0, length)
+	picks = append(picks, Pick{Song: startingSong})
+	// Basic length loop
+	for i := 0; i < length-1; i++ {
+		pick, foundSuffix := pickNext(list, list, constraints, chain, s)
+		if !foundSuffix {
+			pick, foundSuffix = pickFallback(list, constraints, chain, r)
+		}
+		if !foundSuffix {
+			genError = errors.New("There aren't enough songs in your history to fill the playlist.")
+			break
+		}
+		list = append(list, pick.Song)
+		picks = append(picks, pick)
+	}
+	return picks, genError
+}
+
+// resolveSeed finds a song entered by the user in the chain and returns it
+// the way it's displayed in the chain.
+func resolveSeed(chain Chain, song lastFm.Song) (lastFm.Song, bool) {
+	key, exists := findPrefix(chain, song)
+	if !exists {
+		return song, false
+	}
+	song.Artist = chain.Songs[key].Artist
+	song.Title = chain.Songs[key].Title
+	return song, true
+}
+
+// pickNext picks the song to follow context, which must be allowed to be
+// added to the end of list (see Constraints.allowed). The context is usually the list itself.
+// Start with the longest prefix at the end of the context and back off
+// to shorter ones. If even the last song alone doesn't work,
+// keep going back to the start using single songs as the prefix.
+// The pick explains which prefix the song followed (see explain).
+// Returns false if it reaches the start of the context and still can't find a suffix.
+func pickNext(context []lastFm.Song, list []lastFm.Song, constraints Constraints, chain Chain, s *sampler) (Pick, bool) {
+	for _, prefix := range contexts(context, chain.Order) {
+		key := chain.prefixKey(prefix)
+		suffixes, exists := chain.suffixes(key)
+		if !exists || suffixes.Weight <= 0 {
+			continue
+		}
+		for attempts := 0; attempts < maxAttempts; attempts++ {
+			suffix := s.pick(key, suffixes)
+			song := lastFm.Song{Artist: suffix.Artist, Title: suffix.Name}
+			if constraints.allowed(list, song) {
+				return explain(song, prefix, context, suffix, suffixes, chain), true
+			}
+		}
+	}
+	return Pick{}, false
+}
+
+// contexts lists the prefixes to try, in order, for picking the song after list.
+// The longest context at the end of the list (up to order songs) comes first,
+// backing off one song at a time, followed by every earlier song on its own.
+func contexts(list []lastFm.Song, order int) [][]lastFm.Song {
+	var prefixes [][]lastFm.Song
+	for n := order; n > 1; n-- {
+		if n <= len(list) {
+			prefixes = append(prefixes, list[len(list)-n:])
+		}
+	}
+	for j := len(list) - 1; j >= 0; j-- {
+		prefixes = append(prefixes, list[j:j+1])
+	}
+	return prefixes
+}
+
+// findPrefix looks for a single-song prefix in the chain that matches song,
+// ignoring case and punctuation. An exact artist and title match is preferred,
+// then the same title, then a title starting with the one given.
+// If an artist is given, only songs by that artist are matched.
+// When several songs match equally well, the first key in sorted order is used
+// so the result doesn't depend on map iteration order.
+// Returns the song key as it is in the chain.
+func findPrefix(chain Chain, song lastFm.Song) (string, bool) {
+	key := chain.key(song)
+	if chain.isStart(key) {
+		return key, true
+	}
+	fmtArtist := compareArtist(chain.settings.Canonical, song.Artist)
+	fmtTitle := compareTitle(chain.settings.Canonical, song.Title)
+	var candidates []titleKey
+	if len(fmtArtist) > 0 {
+		for _, key := range chain.byArtist[fmtArtist] {
+			title := strings.TrimPrefix(key, fmtArtist+artistSeparator)
+			if chain.isStart(key) && strings.HasPrefix(title, fmtTitle) {
+				candidates = append(candidates, titleKey{title: title, key: key})
+			}
+		}
+	} else {
+		// The titles are sorted, so every title starting with the
+		// one given is in a row.
+		start := sort.Search(len(chain.titles), func(i int) bool {
+			return chain.titles[i].title >= fmtTitle
+		})
+		for i := start; i < len(chain.titles) && strings.HasPrefix(chain.titles[i].title, fmtTitle); i++ {
+			candidates = append(candidates, chain.titles[i])
+		}
+	}
+	found := ""
+	foundExact := false
+	for _, candidate := range candidates {
+		exact := candidate.title == fmtTitle
+		if found == "" || (exact && !foundExact) || (exact == foundExact && candidate.key < found) {
+			found = candidate.key
+			foundExact = exact
+		}
+	}
+	return found, found != ""
+}
+
+// buildCDF creates the distribution to pick suffixes from.
+// Each weight is raised to the power of 1/temperature, so a temperature
+// below 1 favors the most common suffixes and one above 1 flattens the
+// distribution towards rarer ones. A temperature of 1 keeps the raw weights.
+func buildCDF(suffixes []Suffix, temperature float64) CDF {
+	maxWeight := 0.0
+	for _, suffix := range suffixes {
+		if suffix.Weight > maxWeight {
+			maxWeight = suffix.Weight
+		}
+	}
+	cdf := make(CDF, 0, len(suffixes))
+	total := 0.0
+	for j, suffix := range suffixes {
+		if suffix.Weight > 0 {
+			// Relative to the most common suffix so large weights
+			// can't overflow at low temperatures.
+			total += math.Pow(suffix.Weight/maxWeight, 1/temperature)
+			cdf = append(cdf, CDFPoint{Total: total, Index: j})
+		}
+	}
+	return cdf
+}
+
+// clampTemperature keeps a requested temperature in the supported range.
+// Anything not above zero means it wasn't set, so the raw frequencies are used.
+func clampTemperature(temperature float64) float64 {
+	if temperature <= 0 {
+		return 1
+	}
+	return math.Max(MinTemperature, math.Min(MaxTemperature, temperature))
+}
+
+// searchCDF takes a continuous distribution function and returns a random
+// point from that function.
+// Here, it is used to generate an array index that points to the next song to pick,
+// weighted by how likely it is that the next song is listened to.
+//
+// A CDF is defined as a list of points, with one for each option to pick from.
+// Each point's Total is the previous point's Total plus the option's weight,
+// so the points are sorted ascending by Total.
+// The Index can be anything desired. Its value is irrelevant.
+//
+// Behavior:
+// A number is drawn from r in [0, CDF[-1].Total). (CDF[-1] is the last element of the array)
+// The first point with a Total above that number is selected,
+// so each option is picked in proportion to its weight.
+func searchCDF(cdf CDF, r *rand.Rand) int {
+	num := r.Float64() * cdf[len(cdf)-1].Total
+	// Binary search! Look for the first point past the number generated.
+	index := sort.Search(len(cdf), func(i int) bool {
+		return cdf[i].Total > num
+	})
+	if index > len(cdf)-1 {
+		// Only possible through rounding on the last point.
+		index = len(cdf) - 1
+	}
+	return cdf[index].Index
+}
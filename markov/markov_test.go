@@ -0,0 +1,382 @@
+package markov
+
+import (
+	"math/rand"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snyderks/spotkov-web/canonical"
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// testPlays is a small listening history, oldest first, as "Artist - Title".
+// An empty entry starts a new session.
+var testPlays = []string{
+	"Beach House - Myth", "Beach House - Lazuli", "Grizzly Bear - Two Weeks", "Beach House - Myth",
+	"Grizzly Bear - Yet Again", "Fleet Foxes - Mykonos", "Beach House - Lazuli", "Fleet Foxes - Helplessness Blues",
+	"",
+	"Grizzly Bear - Two Weeks", "Fleet Foxes - Mykonos", "Beach House - Myth", "Grizzly Bear - Two Weeks",
+	"Beach House - Space Song", "Fleet Foxes - Mykonos", "Grizzly Bear - Yet Again", "Beach House - Space Song",
+	"",
+	"Fleet Foxes - Helplessness Blues", "Beach House - Myth", "Fleet Foxes - Mykonos", "Grizzly Bear - Two Weeks",
+	"Beach House - Lazuli", "Grizzly Bear - Yet Again", "Beach House - Myth", "Fleet Foxes - Helplessness Blues",
+}
+
+// testHistory turns testPlays into songs three minutes apart,
+// with a day between sessions.
+func testHistory() []lastFm.Song {
+	return playHistory(testPlays)
+}
+
+// playHistory turns plays, as "Artist - Title" with an empty entry between
+// sessions, into songs three minutes apart with a day between sessions.
+func playHistory(plays []string) []lastFm.Song {
+	t := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	var songs []lastFm.Song
+	for _, play := range plays {
+		if play == "" {
+			t = t.Add(24 * time.Hour)
+			continue
+		}
+		parts := strings.SplitN(play, " - ", 2)
+		songs = append(songs, lastFm.Song{Artist: parts[0], Title: parts[1], Timestamp: t})
+		t = t.Add(3 * time.Minute)
+	}
+	return songs
+}
+
+// titles is the titles of the songs picked, in order.
+func titles(picks []Pick) []string {
+	list := make([]string, len(picks))
+	for i, pick := range picks {
+		list[i] = pick.Title
+	}
+	return list
+}
+
+func TestGenerateSongListSameSeed(t *testing.T) {
+	chain := BuildChain(testHistory(), Settings{Order: 2})
+	seed := lastFm.Song{Artist: "Beach House", Title: "Myth"}
+	for _, n := range []int64{1, 2, 42} {
+		first, err := GenerateSongList(7, Constraints{}, seed, chain, 0, rand.New(rand.NewSource(n)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := GenerateSongList(7, Constraints{}, seed, chain, 0, rand.New(rand.NewSource(n)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(first, second) {
+			t.Errorf("seed %d gave different playlists:\n%v\n%v", n, titles(first), titles(second))
+		}
+	}
+}
+
+func TestGenerateSongListGolden(t *testing.T) {
+	chain := BuildChain(testHistory(), Settings{Order: 2})
+	picks, err := GenerateSongList(6, Constraints{ArtistSpacing: 1},
+		lastFm.Song{Artist: "beach house", Title: "myth"}, chain, 0, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"Myth", "Mykonos", "Two Weeks", "Lazuli", "Yet Again", "Space Song"}
+	if got := titles(picks); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if picks[0].Artist != "Beach House" || picks[0].Title != "Myth" {
+		t.Errorf("seed wasn't displayed the way it was played: %v", picks[0].Song)
+	}
+}
+
+func TestGenerateSongListTemperatureSameSeed(t *testing.T) {
+	chain := BuildChain(testHistory(), Settings{Order: 2})
+	seed := lastFm.Song{Artist: "Grizzly Bear", Title: "Two Weeks"}
+	for _, temperature := range []float64{MinTemperature, 0.5, 2, MaxTemperature} {
+		first, err := GenerateSongList(7, Constraints{}, seed, chain, temperature, rand.New(rand.NewSource(7)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := GenerateSongList(7, Constraints{}, seed, chain, temperature, rand.New(rand.NewSource(7)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(first, second) {
+			t.Errorf("temperature %v gave different playlists for the same seed:\n%v\n%v",
+				temperature, titles(first), titles(second))
+		}
+	}
+}
+
+func TestBuildCDFTemperature(t *testing.T) {
+	suffixes := []Suffix{{Name: "a", Weight: 4}, {Name: "b", Weight: 0}, {Name: "c", Weight: 1}}
+	tests := []struct {
+		temperature float64
+		want        CDF
+	}{
+		{1, CDF{{Total: 1, Index: 0}, {Total: 1.25, Index: 2}}},
+		{0.5, CDF{{Total: 1, Index: 0}, {Total: 1.0625, Index: 2}}},
+		{2, CDF{{Total: 1, Index: 0}, {Total: 1.5, Index: 2}}},
+	}
+	for _, test := range tests {
+		if got := buildCDF(suffixes, test.temperature); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("temperature %v: got %v, want %v", test.temperature, got, test.want)
+		}
+	}
+}
+
+func TestBuildChainHalfLife(t *testing.T) {
+	start := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	songs := []lastFm.Song{
+		{Artist: "A", Title: "One", Timestamp: start},
+		{Artist: "A", Title: "Two", Timestamp: start.Add(3 * time.Minute)},
+		{Artist: "A", Title: "One", Timestamp: start.Add(24 * time.Hour)},
+		{Artist: "A", Title: "Three", Timestamp: start.Add(24*time.Hour + 3*time.Minute)},
+	}
+	chain := BuildChain(songs, Settings{Order: 1, HalfLife: 24 * time.Hour})
+	suffixes, exists := chain.suffixes(chain.key(songs[0]))
+	if !exists {
+		t.Fatal("no suffixes for the first song")
+	}
+	weights := make(map[string]float64)
+	for _, suffix := range suffixes.Suffixes {
+		weights[suffix.Name] = suffix.Weight
+	}
+	if weights["Two"] != 0.5 || weights["Three"] != 1 {
+		t.Errorf("got weights %v, want Two to count half as much as Three", weights)
+	}
+	if suffixes.Total != 2 || suffixes.Weight != 1.5 {
+		t.Errorf("got a total of %d and weight of %v, want 2 and 1.5", suffixes.Total, suffixes.Weight)
+	}
+}
+
+func TestGenerateSongListHalfLifeSameSeed(t *testing.T) {
+	chain := BuildChain(testHistory(), Settings{Order: 2, HalfLife: 12 * time.Hour})
+	seed := lastFm.Song{Artist: "Fleet Foxes", Title: "Mykonos"}
+	first, err := GenerateSongList(7, Constraints{}, seed, chain, 0, rand.New(rand.NewSource(3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := GenerateSongList(7, Constraints{}, seed, chain, 0, rand.New(rand.NewSource(3)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Errorf("got different playlists for the same seed:\n%v\n%v", titles(first), titles(second))
+	}
+}
+
+func TestBuildChainOrder(t *testing.T) {
+	chain := BuildChain(testHistory(), Settings{Order: 2})
+	myth := lastFm.Song{Artist: "Beach House", Title: "Myth"}
+	lazuli := lastFm.Song{Artist: "Beach House", Title: "Lazuli"}
+	if _, exists := chain.suffixes(chain.key(myth)); !exists {
+		t.Error("no suffixes for a single song")
+	}
+	if _, exists := chain.suffixes(chain.prefixKey([]lastFm.Song{myth, lazuli})); !exists {
+		t.Error("no suffixes for two songs played in a row")
+	}
+	if chain := BuildChain(testHistory(), Settings{Order: MaxOrder + 1}); chain.Order != MaxOrder {
+		t.Errorf("got order %d, want it clamped to %d", chain.Order, MaxOrder)
+	}
+
+	// Prefixes don't cross sessions.
+	helplessness := lastFm.Song{Artist: "Fleet Foxes", Title: "Helplessness Blues"}
+	twoWeeks := lastFm.Song{Artist: "Grizzly Bear", Title: "Two Weeks"}
+	if _, exists := chain.suffixes(chain.prefixKey([]lastFm.Song{helplessness, twoWeeks})); exists {
+		t.Error("got suffixes for songs in different sessions")
+	}
+}
+
+func TestGenerateSongListBacksOff(t *testing.T) {
+	chain := BuildChain(playHistory([]string{
+		"A - One", "A - Two", "A - Three",
+		"",
+		"B - Four", "A - Two", "B - Five",
+	}), Settings{Order: 2})
+	picks, err := GenerateSongList(3, Constraints{}, lastFm.Song{Artist: "A", Title: "One"}, chain, 0, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := titles(picks), []string{"One", "Two", "Three"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if len(picks[1].Prefix) != 1 || len(picks[2].Prefix) != 2 {
+		t.Errorf("got prefixes %v and %v, want one song then two", picks[1].Prefix, picks[2].Prefix)
+	}
+
+	// Five and Two were never played in that order, so it backs off to Two,
+	// and Five is already in the list.
+	picks, err = ContinueSongList([]lastFm.Song{{Artist: "B", Title: "Five"}, {Artist: "A", Title: "Two"}},
+		1, Constraints{}, chain, 0, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if picks[0].Title != "Three" || len(picks[0].Prefix) != 1 || picks[0].Backtracked {
+		t.Errorf("got %+v, want Three after Two alone", picks[0])
+	}
+
+	// Nothing follows Three, so it goes back to Two.
+	picks, err = ContinueSongList([]lastFm.Song{{Artist: "A", Title: "Two"}, {Artist: "A", Title: "Three"}},
+		1, Constraints{}, chain, 0, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if picks[0].Title != "Five" || !picks[0].Backtracked {
+		t.Errorf("got %+v, want Five backtracked to Two", picks[0])
+	}
+}
+
+func TestBuildChainKeysArtistAndTitle(t *testing.T) {
+	chain := BuildChain(playHistory([]string{
+		"A - Intro", "A - After A",
+		"",
+		"B - Intro", "B - After B",
+	}), Settings{})
+	if len(chain.Songs) != 4 {
+		t.Errorf("got %d songs, want both Intros kept separate", len(chain.Songs))
+	}
+	for _, artist := range []string{"A", "B"} {
+		predictions, err := Predict(lastFm.Song{Artist: artist, Title: "intro"}, -1, chain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(predictions) != 1 || predictions[0].Title != "After "+artist {
+			t.Errorf("%s's Intro got %+v, want only After %s", artist, predictions, artist)
+		}
+	}
+	// Case and punctuation don't split a song in two.
+	if chain.key(lastFm.Song{Artist: "a", Title: "INTRO!"}) != chain.key(lastFm.Song{Artist: "A", Title: "Intro"}) {
+		t.Error("got different keys for the same song written differently")
+	}
+}
+
+func TestBuildChainSkipsAndSessions(t *testing.T) {
+	start := time.Date(2018, 1, 1, 12, 0, 0, 0, time.UTC)
+	songs := []lastFm.Song{
+		{Artist: "A", Title: "One", Timestamp: start},
+		// Skipped, since Three was played 30 seconds after it.
+		{Artist: "A", Title: "Two", Timestamp: start.Add(3 * time.Minute)},
+		{Artist: "A", Title: "Three", Timestamp: start.Add(3*time.Minute + 30*time.Second)},
+		{Artist: "A", Title: "Four", Timestamp: start.Add(7 * time.Minute)},
+		// In another session with a session gap under 20 minutes.
+		{Artist: "A", Title: "Five", Timestamp: start.Add(27 * time.Minute)},
+		{Artist: "A", Title: "Six", Timestamp: start.Add(30 * time.Minute)},
+	}
+	frequency := func(chain Chain, from int, to int) (int, bool) {
+		suffixes, _ := chain.suffixes(chain.key(songs[from]))
+		for _, suffix := range suffixes.Suffixes {
+			if suffix.Name == songs[to].Title {
+				return suffix.Frequency, true
+			}
+		}
+		return 0, false
+	}
+	tests := []struct {
+		settings Settings
+		from, to int
+		want     int
+		counted  bool
+	}{
+		{Settings{}, 0, 1, 1, true},
+		{Settings{SkipGap: time.Minute}, 0, 1, 0, false},
+		{Settings{SkipGap: time.Minute, PenalizeSkips: true}, 0, 1, -1, true},
+		// Songs played after a skip still count.
+		{Settings{SkipGap: time.Minute}, 1, 2, 1, true},
+		{Settings{}, 3, 4, 1, true},
+		{Settings{SessionGap: 20 * time.Minute}, 3, 4, 0, false},
+		{Settings{SessionGap: 20 * time.Minute}, 4, 5, 1, true},
+	}
+	for _, test := range tests {
+		chain := BuildChain(songs, test.settings)
+		got, counted := frequency(chain, test.from, test.to)
+		if got != test.want || counted != test.counted {
+			t.Errorf("%+v: %s to %s got %d (counted %v), want %d (counted %v)", test.settings,
+				songs[test.from].Title, songs[test.to].Title, got, counted, test.want, test.counted)
+		}
+	}
+
+	// A song counted against more than it happened can't be picked.
+	chain := BuildChain(songs, Settings{SkipGap: time.Minute, PenalizeSkips: true})
+	if suffixes, _ := chain.suffixes(chain.key(songs[0])); suffixes.Total != 0 || suffixes.Weight != 0 {
+		t.Errorf("got a total of %d and weight of %v after a skip, want 0", suffixes.Total, suffixes.Weight)
+	}
+}
+
+func TestHourAndWeekdayMasks(t *testing.T) {
+	if got := HourMask(0, 23, 24, -1); got != 1|1<<23 {
+		t.Errorf("HourMask got %b, want only hours 0 and 23", got)
+	}
+	if got := WeekdayMask(time.Sunday, time.Saturday); got != 1|1<<6 {
+		t.Errorf("WeekdayMask got %b, want only Sunday and Saturday", got)
+	}
+
+	// 2018-01-01 was a Monday.
+	morning := time.Date(2018, 1, 1, 9, 0, 0, 0, time.UTC)
+	evening := time.Date(2018, 1, 6, 21, 0, 0, 0, time.UTC)
+	songs := []lastFm.Song{
+		{Artist: "A", Title: "One", Timestamp: morning},
+		{Artist: "A", Title: "Morning", Timestamp: morning.Add(3 * time.Minute)},
+		{Artist: "A", Title: "One", Timestamp: evening},
+		{Artist: "A", Title: "Evening", Timestamp: evening.Add(3 * time.Minute)},
+	}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no timezone data:", err)
+	}
+	tests := []struct {
+		settings Settings
+		want     []string
+	}{
+		{Settings{}, []string{"Evening", "Morning"}},
+		{Settings{Hours: HourMask(9)}, []string{"Morning"}},
+		{Settings{Hours: HourMask(21)}, []string{"Evening"}},
+		{Settings{Weekdays: WeekdayMask(time.Monday)}, []string{"Morning"}},
+		{Settings{Weekdays: WeekdayMask(time.Saturday, time.Sunday)}, []string{"Evening"}},
+		{Settings{Hours: HourMask(9), Weekdays: WeekdayMask(time.Saturday)}, nil},
+		// 9 in the morning in UTC is 4 in New York.
+		{Settings{Hours: HourMask(4), Location: newYork}, []string{"Morning"}},
+	}
+	for _, test := range tests {
+		chain := BuildChain(songs, test.settings)
+		suffixes, _ := chain.suffixes(chain.key(songs[0]))
+		var got []string
+		for _, suffix := range suffixes.Suffixes {
+			got = append(got, suffix.Name)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%+v: got %q, want %q", test.settings, got, test.want)
+		}
+	}
+}
+
+func TestBuildChainCanonical(t *testing.T) {
+	plays := []string{
+		"The Band - Song (Remastered 2011)", "!!! - One", "Band - Song - Live", "... - One", "The Band - Song",
+	}
+	tests := []struct {
+		rules canonical.Rules
+		songs int
+	}{
+		// Every Song is the same, but the bands named only with
+		// punctuation aren't.
+		{canonical.Rules{}, 3},
+		{canonical.Rules{KeepVersions: true}, 5},
+		{canonical.Rules{KeepArticles: true}, 4},
+		{canonical.Rules{KeepVersions: true, KeepArticles: true}, 5},
+	}
+	for _, test := range tests {
+		chain := BuildChain(playHistory(plays), Settings{Canonical: test.rules})
+		if len(chain.Songs) != test.songs {
+			t.Errorf("%+v: got %d songs, want %d", test.rules, len(chain.Songs), test.songs)
+		}
+	}
+	chain := BuildChain(playHistory(plays), Settings{})
+	song := chain.Songs[chain.key(lastFm.Song{Artist: "Band", Title: "Song"})]
+	if song.Artist != "The Band" || song.Title != "Song (Remastered 2011)" {
+		t.Errorf("got %v, want it displayed the first way it was played", song)
+	}
+}
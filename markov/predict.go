@@ -0,0 +1,58 @@
+package markov
+
+import (
+	"errors"
+	"sort"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// Prediction is a song that could be played next, with how likely it is.
+type Prediction struct {
+	Artist string
+	Title  string
+	// Count is the number of times the song was played next.
+	Count int
+	// Probability is the chance of the song being played next,
+	// out of all the songs that ever were.
+	Probability float64
+}
+
+// Predict finds the k songs most likely to be played after song in the chain,
+// most likely first. Unlike GenerateSongList, nothing is picked at random.
+// Songs with the same probability are sorted by how many times they were
+// played next, then by artist and title, so the order is always the same.
+// Returns an error if the song can't be found.
+func Predict(song lastFm.Song, k int, chain Chain) ([]Prediction, error) {
+	key, exists := findPrefix(chain, song)
+	if !exists {
+		return nil, errors.New("The song you entered couldn't be found. Please try again.")
+	}
+	suffixes, _ := chain.suffixes(key)
+	predictions := make([]Prediction, 0, len(suffixes.Suffixes))
+	for _, suffix := range suffixes.Suffixes {
+		if suffix.Weight <= 0 {
+			continue
+		}
+		predictions = append(predictions, Prediction{
+			Artist:      suffix.Artist,
+			Title:       suffix.Name,
+			Count:       suffix.Frequency,
+			Probability: suffix.Weight / suffixes.Weight,
+		})
+	}
+	sort.Slice(predictions, func(i, j int) bool {
+		a, b := predictions[i], predictions[j]
+		if a.Probability != b.Probability {
+			return a.Probability > b.Probability
+		}
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Artist+artistSeparator+a.Title < b.Artist+artistSeparator+b.Title
+	})
+	if k >= 0 && len(predictions) > k {
+		predictions = predictions[:k]
+	}
+	return predictions, nil
+}
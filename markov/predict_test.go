@@ -0,0 +1,39 @@
+package markov
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+func TestPredict(t *testing.T) {
+	chain := BuildChain(playHistory([]string{
+		"A - One", "A - Two", "", "A - One", "A - Two", "", "A - One", "B - Three",
+		"", "A - One", "C - Four", "", "A - One", "A - Two",
+	}), Settings{})
+	seed := lastFm.Song{Artist: "A", Title: "One"}
+	predictions, err := Predict(seed, -1, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Three and Four are as likely, so they're by artist.
+	want := []Prediction{
+		{Artist: "A", Title: "Two", Count: 3, Probability: 3.0 / 5},
+		{Artist: "B", Title: "Three", Count: 1, Probability: 1.0 / 5},
+		{Artist: "C", Title: "Four", Count: 1, Probability: 1.0 / 5},
+	}
+	if !reflect.DeepEqual(predictions, want) {
+		t.Errorf("got %+v, want %+v", predictions, want)
+	}
+	predictions, err = Predict(seed, 2, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(predictions, want[:2]) {
+		t.Errorf("got %+v, want the first two", predictions)
+	}
+	if _, err := Predict(lastFm.Song{Artist: "D", Title: "Five"}, 1, chain); err == nil {
+		t.Error("got predictions for a song that was never played")
+	}
+}
@@ -0,0 +1,126 @@
+package markov
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// rerollCandidates is how many of the most played songs are tried for a slot
+// along with the ones that follow the songs before it.
+const rerollCandidates = 200
+
+// Alternative is a song that could take the place of one in a playlist.
+type Alternative struct {
+	Artist string
+	Title  string
+	// After is the chance of the song following the songs before the slot,
+	// and Before is the chance of the song after the slot following it.
+	// Both are smoothed (see smoothedProb) so a song that never followed
+	// another still has a small chance, and each is 1 if there's no song
+	// on that side.
+	After  float64
+	Before float64
+	// LogProbability is the log of After times Before.
+	LogProbability float64
+}
+
+// Alternatives finds the k songs that best fit in place of the song at index
+// in list, best first. The best fit is the most likely to follow the songs
+// before the slot and to be followed by the song after it. Every alternative
+// follows the constraints (see Constraints.allowedAt), so none of them are
+// already in the list, and the song in the slot isn't one either.
+// Nothing is picked at random, so the same list always gets the same
+// alternatives. Returns an error if index isn't in the list.
+func Alternatives(list []lastFm.Song, index int, k int, constraints Constraints, chain Chain) ([]Alternative, error) {
+	if index < 0 || index >= len(list) {
+		return nil, errors.New("There isn't a song at that spot in the playlist.")
+	}
+	constraints = constraints.prepare(chain)
+	totalPlays := 0
+	for _, plays := range chain.Plays {
+		totalPlays += plays
+	}
+	smooth := func(chainProb float64, key string) float64 {
+		return smoothedProb(chainProb, chain.Plays[key], totalPlays, len(chain.Plays))
+	}
+
+	// The candidates are the songs that follow the songs before the slot,
+	// and the most played songs in case those aren't enough.
+	before := list[:index]
+	var following Suffixes
+	for _, prefix := range contexts(before, chain.Order) {
+		suffixes, exists := chain.suffixes(chain.prefixKey(prefix))
+		if exists && suffixes.Weight > 0 {
+			following = suffixes
+			break
+		}
+	}
+	chainProbs := make(map[string]float64)
+	var candidates []lastFm.Song
+	for _, suffix := range following.Suffixes {
+		if suffix.Weight <= 0 {
+			continue
+		}
+		song := lastFm.Song{Artist: suffix.Artist, Title: suffix.Name}
+		chainProbs[chain.key(song)] = suffix.Weight / following.Weight
+		candidates = append(candidates, song)
+	}
+	for i := 0; i < len(chain.popular) && i < rerollCandidates; i++ {
+		key := chain.popular[i]
+		if _, exists := chainProbs[key]; !exists {
+			chainProbs[key] = 0
+			candidates = append(candidates, lastFm.Song{Artist: chain.Songs[key].Artist, Title: chain.Songs[key].Title})
+		}
+	}
+
+	current := chain.key(list[index])
+	var alternatives []Alternative
+	for _, song := range candidates {
+		key := chain.key(song)
+		if key == current || !constraints.allowedAt(list, index, song) {
+			continue
+		}
+		alternative := Alternative{Artist: song.Artist, Title: song.Title, After: 1, Before: 1}
+		if index > 0 {
+			alternative.After = smooth(chainProbs[key], key)
+		}
+		if index+1 < len(list) {
+			alternative.Before = smooth(followProb(chain, append(before[:index:index], song), list[index+1]), chain.key(list[index+1]))
+		}
+		alternative.LogProbability = math.Log(alternative.After) + math.Log(alternative.Before)
+		alternatives = append(alternatives, alternative)
+	}
+	sort.SliceStable(alternatives, func(i, j int) bool {
+		return alternatives[i].LogProbability > alternatives[j].LogProbability
+	})
+	if k >= 0 && len(alternatives) > k {
+		alternatives = alternatives[:k]
+	}
+	return alternatives, nil
+}
+
+// followProb is the chance of song following the end of list in the chain,
+// from the longest prefix at the end of list that has suffixes.
+// It's 0 if there isn't one.
+func followProb(chain Chain, list []lastFm.Song, song lastFm.Song) float64 {
+	key := chain.key(song)
+	for n := chain.Order; n >= 1; n-- {
+		if n > len(list) {
+			continue
+		}
+		suffixes, exists := chain.suffixes(chain.prefixKey(list[len(list)-n:]))
+		if !exists || suffixes.Weight <= 0 {
+			continue
+		}
+		for _, suffix := range suffixes.Suffixes {
+			if suffix.Weight > 0 && chain.key(lastFm.Song{Artist: suffix.Artist, Title: suffix.Name}) == key {
+				return suffix.Weight / suffixes.Weight
+			}
+		}
+		return 0
+	}
+	return 0
+}
@@ -0,0 +1,70 @@
+package markov
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+func TestAlternatives(t *testing.T) {
+	chain := BuildChain(testHistory(), Settings{})
+	list := []lastFm.Song{
+		{Artist: "Beach House", Title: "Myth"},
+		{Artist: "Fleet Foxes", Title: "Mykonos"},
+		{Artist: "Grizzly Bear", Title: "Two Weeks"},
+	}
+	constraints := Constraints{ArtistSpacing: 1}
+	for index := range list {
+		alternatives, err := Alternatives(list, index, -1, constraints, chain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(alternatives) == 0 {
+			t.Errorf("%d: got no alternatives", index)
+		}
+		if !sort.SliceIsSorted(alternatives, func(i, j int) bool {
+			return alternatives[i].LogProbability > alternatives[j].LogProbability
+		}) {
+			t.Errorf("%d: got %+v, want the best fit first", index, alternatives)
+		}
+		prepared := constraints.prepare(chain)
+		for _, alternative := range alternatives {
+			song := lastFm.Song{Artist: alternative.Artist, Title: alternative.Title}
+			if song.Title == list[index].Title || !prepared.allowedAt(list, index, song) {
+				t.Errorf("%d: got %q, which isn't allowed there", index, song.Title)
+			}
+			if alternative.After <= 0 || alternative.After > 1 || alternative.Before <= 0 || alternative.Before > 1 ||
+				!closeTo(alternative.LogProbability, math.Log(alternative.After)+math.Log(alternative.Before)) {
+				t.Errorf("%d: got %+v, want probabilities that add up", index, alternative)
+			}
+			// There's nothing to follow at the start or lead to at the end.
+			if (index == 0 && alternative.After != 1) || (index == len(list)-1 && alternative.Before != 1) {
+				t.Errorf("%d: got %+v, want 1 for the side without a song", index, alternative)
+			}
+		}
+		first, _ := Alternatives(list, index, 1, constraints, chain)
+		if !reflect.DeepEqual(first, alternatives[:1]) {
+			t.Errorf("%d: got %+v, want only the best fit", index, first)
+		}
+	}
+
+	// Lazuli was played right after Myth and right before Two Weeks, and
+	// Space Song never was, so Lazuli fits better.
+	alternatives, err := Alternatives(list, 1, -1, Constraints{}, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rank := make(map[string]int)
+	for i, alternative := range alternatives {
+		rank[alternative.Title] = i
+	}
+	if rank["Lazuli"] > rank["Space Song"] {
+		t.Errorf("got %+v, want Lazuli ahead of Space Song", alternatives)
+	}
+	if _, err := Alternatives(list, len(list), 1, constraints, chain); err == nil {
+		t.Error("got alternatives for a song past the end of the playlist")
+	}
+}
@@ -0,0 +1,59 @@
+package markov
+
+import (
+	"errors"
+	"math/rand"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// errNotReverse is returned when a playlist is made to end on a song
+// from a chain that wasn't built in reverse.
+var errNotReverse = errors.New("The chain has to be built in reverse to end on a song.")
+
+// GenerateEndingSongList creates a playlist that ends with endingSong from a
+// chain built with Settings.Reverse. It walks back from the song the same way
+// GenerateSongList walks forward, then returns the playlist in the order it's
+// played. The constraints are checked in the order the songs are picked, which
+// gives the same result for the ones that don't depend on order.
+// Each pick's Prefix is the songs it was picked to come before, in the order
+// they're played, and Count is how many times it was played before them.
+// Returns an error if the chain isn't a reverse one.
+func GenerateEndingSongList(length int, constraints Constraints, endingSong lastFm.Song, chain Chain, temperature float64, r *rand.Rand) ([]Pick, error) {
+	if !chain.settings.Reverse {
+		return nil, errNotReverse
+	}
+	picks, err := GenerateSongList(length, constraints, endingSong, chain, temperature, r)
+	for i, j := 0, len(picks)-1; i < j; i, j = i+1, j-1 {
+		picks[i], picks[j] = picks[j], picks[i]
+	}
+	for _, pick := range picks {
+		reverseBaseSongs(pick.Prefix)
+	}
+	return picks, err
+}
+
+// BestEndingSongLists finds the n most likely playlists that end with
+// endingSong from a chain built with Settings.Reverse, the same way
+// BestSongLists finds the ones that start with a song, and returns them in the
+// order they're played. Returns an error if the chain isn't a reverse one.
+func BestEndingSongLists(length int, n int, constraints Constraints, endingSong lastFm.Song, chain Chain) ([]ScoredList, error) {
+	if !chain.settings.Reverse {
+		return nil, errNotReverse
+	}
+	lists, err := BestSongLists(length, n, constraints, endingSong, chain)
+	for _, list := range lists {
+		songs := list.Songs
+		for i, j := 0, len(songs)-1; i < j; i, j = i+1, j-1 {
+			songs[i], songs[j] = songs[j], songs[i]
+		}
+	}
+	return lists, err
+}
+
+// reverseBaseSongs reverses the order of songs in place.
+func reverseBaseSongs(songs []lastFm.BaseSong) {
+	for i, j := 0, len(songs)-1; i < j; i, j = i+1, j-1 {
+		songs[i], songs[j] = songs[j], songs[i]
+	}
+}
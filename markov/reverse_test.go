@@ -0,0 +1,43 @@
+package markov
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+func TestGenerateEndingSongList(t *testing.T) {
+	history := playHistory([]string{"A - One", "A - Two", "A - Three", "A - Four"})
+	chain := BuildChain(history, Settings{Order: 2, Reverse: true})
+	ending := lastFm.Song{Artist: "A", Title: "Four"}
+	picks, err := GenerateEndingSongList(4, Constraints{}, ending, chain, 0, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := titles(picks), []string{"One", "Two", "Three", "Four"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	// One was picked to come before Two and Three, in the order they're played.
+	want := []lastFm.BaseSong{{Artist: "A", Title: "Two"}, {Artist: "A", Title: "Three"}}
+	if !reflect.DeepEqual(picks[0].Prefix, want) || picks[0].Count != 1 {
+		t.Errorf("got %v played %d times before it, want %v once", picks[0].Prefix, picks[0].Count, want)
+	}
+
+	lists, err := BestEndingSongLists(3, 1, Constraints{}, ending, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := songTitles(lists[0].Songs); !reflect.DeepEqual(got, []string{"Two", "Three", "Four"}) {
+		t.Errorf("got %q, want the songs ending on Four", got)
+	}
+
+	forward := BuildChain(history, Settings{Order: 2})
+	if _, err := GenerateEndingSongList(4, Constraints{}, ending, forward, 0, rand.New(rand.NewSource(1))); err != errNotReverse {
+		t.Errorf("got %v from a forward chain, want errNotReverse", err)
+	}
+	if _, err := BestEndingSongLists(3, 1, Constraints{}, ending, forward); err != errNotReverse {
+		t.Errorf("got %v from a forward chain, want errNotReverse", err)
+	}
+}
@@ -0,0 +1,324 @@
+package markov
+
+import (
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/snyderks/spotkov-web/canonical"
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// SimilarSong is a song like another one from the same history,
+// with how alike they are by each measure, from 0 to 1.
+type SimilarSong struct {
+	Artist string
+	Title  string
+	// Score is the average of the measures below.
+	Score float64
+	// Sessions is how often the songs are played in the same sessions,
+	// compared to how many sessions each is played in at all.
+	Sessions float64
+	// Neighbors is how much the songs are played right before and after
+	// the same songs, whether or not they're ever played together.
+	Neighbors float64
+	// Embedding is how close the songs are in the embeddings, if there
+	// were any, with songs in opposite directions counting as 0.
+	Embedding float64 `json:",omitempty"`
+}
+
+// songGraph is a history split into sessions, along with which songs
+// are played right next to each other in them.
+type songGraph struct {
+	keys     []string
+	songs    []lastFm.BaseSong // how each song is displayed
+	plays    []int             // times each song was played
+	sessions [][]int           // the distinct songs in each session, by index
+	in       [][]int           // the sessions each song is in, by index
+	// adjacent is the number of times each song was played
+	// right before or after each other song.
+	adjacent []map[int]float64
+}
+
+// SimilarityIndex is the songs most like every song in a history, worked out
+// ahead of time (see IndexSimilarSongs) so they can be looked up without it.
+type SimilarityIndex struct {
+	// Similar is the songs most like each song, most alike first,
+	// by the song's key (see SimilarKey).
+	Similar map[string][]SimilarSong
+	// MostPlayed is the key of the most played song with each title,
+	// by the title's key (see SimilarTitleKey), for songs looked up
+	// without their artist.
+	MostPlayed map[string]string
+}
+
+// SimilarSongs finds the k songs in a history, oldest first, most like song.
+// Songs are alike if they're played in the same sessions, split by the
+// session gap in settings, and if they're played right before and after the
+// same songs, like the transitions in a chain of order 1. If embeddings
+// trained with the same settings are given, how close the songs are in them
+// counts too, which finds songs that have never been played near each other.
+// Songs are identified with the canonicalization rules in settings.
+// If no artist is given, the most played song with the title is used.
+// Returns an error if the song isn't in the history.
+func SimilarSongs(song lastFm.Song, k int, songs []lastFm.Song, settings Settings, embeddings *Embeddings) ([]SimilarSong, error) {
+	settings = settings.withDefaults()
+	graph := newSongGraph(songs, settings)
+	target, exists := graph.find(song, settings)
+	if !exists {
+		return nil, errors.New("The song you entered couldn't be found. Please try again.")
+	}
+	return graph.similarTo(target, k, embeddings, graph.vectors(embeddings)), nil
+}
+
+// IndexSimilarSongs finds the k songs most like every song in a history,
+// oldest first, the same way SimilarSongs does, so they can be looked up
+// later without the history or going over it again for each song.
+func IndexSimilarSongs(k int, songs []lastFm.Song, settings Settings, embeddings *Embeddings) SimilarityIndex {
+	settings = settings.withDefaults()
+	graph := newSongGraph(songs, settings)
+	vectors := graph.vectors(embeddings)
+	index := SimilarityIndex{
+		Similar:    make(map[string][]SimilarSong, len(graph.keys)),
+		MostPlayed: make(map[string]string),
+	}
+	mostPlayed := make(map[string]int)
+	for target, key := range graph.keys {
+		index.Similar[key] = graph.similarTo(target, k, embeddings, vectors)
+		title := titleOf(key)
+		if found, exists := mostPlayed[title]; !exists || graph.morePlayed(target, found) {
+			mostPlayed[title] = target
+		}
+	}
+	for title, target := range mostPlayed {
+		index.MostPlayed[title] = graph.keys[target]
+	}
+	return index
+}
+
+// SimilarKey is the key a song's similar songs are kept under in a
+// SimilarityIndex built with the canonicalization rules.
+func SimilarKey(rules canonical.Rules, artist string, title string) string {
+	return songKey(rules, artist, title)
+}
+
+// SimilarTitleKey is the key the most played song with a title is kept under
+// in a SimilarityIndex built with the canonicalization rules.
+func SimilarTitleKey(rules canonical.Rules, title string) string {
+	return compareTitle(rules, title)
+}
+
+// similarTo finds the k songs in the graph most like the one with the index,
+// or all of them if k is negative. vectors is the index of each song in the
+// embeddings (see vectors), or nil if there aren't any.
+func (g songGraph) similarTo(target int, k int, embeddings *Embeddings, vectors []int) []SimilarSong {
+	together := make(map[int]float64)
+	for _, session := range g.in[target] {
+		for _, other := range g.sessions[session] {
+			if other != target {
+				together[other]++
+			}
+		}
+	}
+
+	// Cosine similarity between the songs each song is played next to,
+	// for every song sharing at least one of them with the target.
+	shared := make(map[int]float64)
+	for neighbor, weight := range g.adjacent[target] {
+		for other, otherWeight := range g.adjacent[neighbor] {
+			if other != target {
+				shared[other] += weight * otherWeight
+			}
+		}
+	}
+
+	if vectors != nil && vectors[target] < 0 {
+		vectors = nil
+	}
+	targetNorm := g.adjacencyNorm(target)
+	var similar []SimilarSong
+	for other := range g.keys {
+		if other == target {
+			continue
+		}
+		match := SimilarSong{}
+		measures := 2.0
+		if count, found := together[other]; found {
+			match.Sessions = count / math.Sqrt(float64(len(g.in[target])*len(g.in[other])))
+		}
+		if product, found := shared[other]; found {
+			match.Neighbors = product / (targetNorm * g.adjacencyNorm(other))
+		}
+		if vectors != nil {
+			measures++
+			if vectors[other] >= 0 {
+				a, b := embeddings.vector(vectors[target]), embeddings.vector(vectors[other])
+				if n := norm(a) * norm(b); n > 0 {
+					match.Embedding = math.Max(0, float64(dot(a, b))/n)
+				}
+			}
+		}
+		match.Score = (match.Sessions + match.Neighbors + match.Embedding) / measures
+		if match.Score <= 0 {
+			continue
+		}
+		match.Artist, match.Title = g.songs[other].Artist, g.songs[other].Title
+		similar = insertSimilar(similar, match, k)
+	}
+	if similar == nil {
+		similar = []SimilarSong{}
+	}
+	return similar
+}
+
+// insertSimilar adds a song to songs, most alike first, keeping at most k
+// of them, or all of them if k is negative.
+func insertSimilar(songs []SimilarSong, song SimilarSong, k int) []SimilarSong {
+	if k == 0 || (k > 0 && len(songs) == k && !moreSimilar(song, songs[k-1])) {
+		return songs
+	}
+	i := sort.Search(len(songs), func(i int) bool {
+		return moreSimilar(song, songs[i])
+	})
+	if k < 0 || len(songs) < k {
+		songs = append(songs, SimilarSong{})
+	}
+	copy(songs[i+1:], songs[i:])
+	songs[i] = song
+	return songs
+}
+
+// moreSimilar reports whether a is more alike than b, or comes first by
+// artist and title if they're as alike.
+func moreSimilar(a SimilarSong, b SimilarSong) bool {
+	if a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return a.Artist+artistSeparator+a.Title < b.Artist+artistSeparator+b.Title
+}
+
+// vectors finds the index of each song in the graph in the embeddings,
+// or -1 if it isn't in them. Returns nil without any embeddings.
+func (g songGraph) vectors(embeddings *Embeddings) []int {
+	if embeddings == nil {
+		return nil
+	}
+	positions := make(map[string]int, len(embeddings.Keys))
+	for i, key := range embeddings.Keys {
+		positions[key] = i
+	}
+	vectors := make([]int, len(g.keys))
+	for i, key := range g.keys {
+		position, found := positions[key]
+		if !found {
+			position = -1
+		}
+		vectors[i] = position
+	}
+	return vectors
+}
+
+// newSongGraph splits a history, oldest first, into sessions with the
+// session gap in settings, identifying songs with its canonicalization rules.
+func newSongGraph(songs []lastFm.Song, settings Settings) songGraph {
+	graph := songGraph{}
+	keys := make(map[lastFm.BaseSong]string)
+	indexes := make(map[string]int)
+	var session []int
+	for i, song := range songs {
+		base := lastFm.BaseSong{Artist: song.Artist, Title: song.Title}
+		key := lookupKey(keys, settings.Canonical, song)
+		keys[base] = key
+		index, exists := indexes[key]
+		if !exists {
+			index = len(graph.keys)
+			indexes[key] = index
+			graph.keys = append(graph.keys, key)
+			graph.songs = append(graph.songs, base)
+			graph.plays = append(graph.plays, 0)
+			graph.in = append(graph.in, nil)
+			graph.adjacent = append(graph.adjacent, make(map[int]float64))
+		}
+		graph.plays[index]++
+		if i > 0 && !settings.sameSession(songs[i-1:i+1]) {
+			graph.addSession(session)
+			session = nil
+		}
+		if len(session) > 0 {
+			previous := session[len(session)-1]
+			if previous == index {
+				continue
+			}
+			graph.adjacent[previous][index]++
+			graph.adjacent[index][previous]++
+		}
+		session = append(session, index)
+	}
+	graph.addSession(session)
+	return graph
+}
+
+// addSession adds a session to the graph.
+func (g *songGraph) addSession(session []int) {
+	if len(session) == 0 {
+		return
+	}
+	unique := distinct(session)
+	for _, index := range unique {
+		g.in[index] = append(g.in[index], len(g.sessions))
+	}
+	g.sessions = append(g.sessions, unique)
+}
+
+// find looks for a song in the graph, returning its index. If no artist is
+// given, the most played song with the title is used.
+func (g songGraph) find(song lastFm.Song, settings Settings) (int, bool) {
+	if len(song.Artist) > 0 {
+		key := songKey(settings.Canonical, song.Artist, song.Title)
+		for i, k := range g.keys {
+			if k == key {
+				return i, true
+			}
+		}
+		return 0, false
+	}
+	title := compareTitle(settings.Canonical, song.Title)
+	found := -1
+	for i, key := range g.keys {
+		if titleOf(key) != title {
+			continue
+		}
+		if found < 0 || g.morePlayed(i, found) {
+			found = i
+		}
+	}
+	return found, found >= 0
+}
+
+// morePlayed reports whether the song with index a was played more than the
+// one with index b, or comes first by key if they were played as often.
+func (g songGraph) morePlayed(a int, b int) bool {
+	return g.plays[a] > g.plays[b] || (g.plays[a] == g.plays[b] && g.keys[a] < g.keys[b])
+}
+
+// adjacencyNorm is the length of a song's adjacency counts as a vector.
+func (g songGraph) adjacencyNorm(index int) float64 {
+	sum := 0.0
+	for _, count := range g.adjacent[index] {
+		sum += count * count
+	}
+	return math.Sqrt(sum)
+}
+
+// distinct is the indexes in a session without any repeats.
+func distinct(session []int) []int {
+	seen := make(map[int]bool, len(session))
+	unique := make([]int, 0, len(session))
+	for _, index := range session {
+		if !seen[index] {
+			seen[index] = true
+			unique = append(unique, index)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,67 @@
+package markov
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/snyderks/spotkov-web/canonical"
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+func TestSimilarSongs(t *testing.T) {
+	songs := playHistory(clusterPlays())
+	similar, err := SimilarSongs(lastFm.Song{Artist: "A", Title: "One"}, 3, songs, Settings{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(similar) != 3 {
+		t.Fatalf("got %+v, want 3 songs", similar)
+	}
+	for _, song := range similar {
+		if song.Artist != "A" || song.Title == "One" || song.Sessions != 1 || song.Embedding != 0 {
+			t.Errorf("got %+v, want the other songs always played with One", song)
+		}
+	}
+	// Without an artist, it's the most played song with the title.
+	withoutArtist, err := SimilarSongs(lastFm.Song{Title: "one"}, 3, songs, Settings{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(withoutArtist, similar) {
+		t.Errorf("got %+v without the artist, want %+v", withoutArtist, similar)
+	}
+	if _, err := SimilarSongs(lastFm.Song{Artist: "B", Title: "One"}, 3, songs, Settings{}, nil); err == nil {
+		t.Error("got similar songs for a song that was never played")
+	}
+}
+
+func TestIndexSimilarSongs(t *testing.T) {
+	plays := append(clusterPlays(), "", "C - One", "A - Two", "", "C - One", "B - Five")
+	songs := playHistory(plays)
+	embeddings := TrainEmbeddings(songs, Settings{}, EmbeddingSettings{Dimensions: 8, Subsample: 1, Seed: 1})
+	for _, e := range []*Embeddings{nil, &embeddings} {
+		index := IndexSimilarSongs(4, songs, Settings{}, e)
+		if len(index.Similar) != 9 {
+			t.Errorf("got %d songs, want 9", len(index.Similar))
+		}
+		for key, indexed := range index.Similar {
+			parts := lastFm.Song{Artist: artistOf(key), Title: titleOf(key)}
+			similar, err := SimilarSongs(parts, 4, songs, Settings{}, e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(indexed, similar) {
+				t.Errorf("%q got %+v, want the same as SimilarSongs: %+v", key, indexed, similar)
+			}
+		}
+		// A's One was played more than C's.
+		if got, want := index.MostPlayed[SimilarTitleKey(canonical.Rules{}, "One")], SimilarKey(canonical.Rules{}, "A", "One"); got != want {
+			t.Errorf("got %q as the most played One, want %q", got, want)
+		}
+	}
+}
+
+// artistOf is the artist of a song key.
+func artistOf(key string) string {
+	return key[:len(key)-len(titleOf(key))-len(artistSeparator)]
+}
@@ -0,0 +1,191 @@
+package markov
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// Damping is the chance of following the chain at each step of the walk the
+// stationary distribution comes from, rather than jumping to any song at random.
+const Damping = 0.85
+
+// stationaryIterations and stationaryTolerance bound how long the stationary
+// distribution is worked out for: until it changes by less than the tolerance
+// in one step, or for at most that many steps.
+const (
+	stationaryIterations = 100
+	stationaryTolerance  = 1e-9
+)
+
+// Centrality is a song's share of the chain's stationary distribution,
+// which is how often a long walk through the chain would play it.
+type Centrality struct {
+	Artist string
+	Title  string
+	Score  float64
+	Plays  int
+}
+
+// stationaryCache holds a chain's stationary distribution once it's been
+// worked out, since it doesn't change until the chain does.
+type stationaryCache struct {
+	once         sync.Once
+	scores       map[string]float64 // each song's score, by key
+	distribution []Centrality
+}
+
+// edge is a transition from one song to another, by index, with its probability.
+type edge struct {
+	to          int
+	probability float64
+}
+
+// StationaryDistribution works out how often each song in the chain would be
+// played on a long walk through its single-song prefixes, most often first,
+// like PageRank. At each step the walk follows the chain with a chance of
+// Damping, and otherwise jumps to any song, as it always does from a song
+// nothing follows, so the many dead ends in a chain don't trap it.
+// Songs with the same score are sorted by plays, then by key, so the order is
+// always the same. The scores add up to 1.
+// It's worked out once for a chain made by BuildChain, the first time it's
+// needed, and kept when the chain is extended, since working it out again
+// goes over the whole chain. A chain made by LoadChain never fetches its
+// prefixes for it, and uses the scores in its header instead. Either way,
+// songs added by extending the chain have a score of 0 until it's built again.
+func (c Chain) StationaryDistribution() []Centrality {
+	return c.centrality().distribution
+}
+
+// centrality finds the chain's stationary distribution, working it out the
+// first time it's needed if the chain is in memory (see StationaryDistribution).
+func (c Chain) centrality() *stationaryCache {
+	stationary := c.stationary
+	if stationary == nil {
+		stationary = &stationaryCache{}
+	}
+	stationary.once.Do(func() {
+		if stationary.scores == nil && c.store == nil {
+			stationary.scores = c.stationaryScores()
+		}
+		stationary.distribution = c.sortByScore(stationary.scores)
+	})
+	return stationary
+}
+
+// stationaryScores works out each song's share of the stationary distribution
+// of the chain, by key (see StationaryDistribution).
+func (c Chain) stationaryScores() map[string]float64 {
+	keys := c.popular
+	n := len(keys)
+	scores := make(map[string]float64, n)
+	if n == 0 {
+		return scores
+	}
+	indexes := make(map[string]int, n)
+	for i, key := range keys {
+		indexes[key] = i
+	}
+	edges := make([][]edge, n)
+	for i, key := range keys {
+		if !c.isStart(key) {
+			continue
+		}
+		suffixes, exists := c.suffixes(key)
+		if !exists || suffixes.Weight <= 0 {
+			continue
+		}
+		for _, suffix := range suffixes.Suffixes {
+			if suffix.Weight <= 0 {
+				continue
+			}
+			if to, found := indexes[c.key(lastFm.Song{Artist: suffix.Artist, Title: suffix.Name})]; found {
+				edges[i] = append(edges[i], edge{to: to, probability: suffix.Weight / suffixes.Weight})
+			}
+		}
+	}
+
+	rank := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+	next := make([]float64, n)
+	for iteration := 0; iteration < stationaryIterations; iteration++ {
+		dangling := 0.0
+		for i := range next {
+			next[i] = 0
+		}
+		for i, out := range edges {
+			if len(out) == 0 {
+				dangling += rank[i]
+				continue
+			}
+			for _, e := range out {
+				next[e.to] += Damping * rank[i] * e.probability
+			}
+		}
+		jump := (1-Damping)/float64(n) + Damping*dangling/float64(n)
+		change := 0.0
+		for i := range next {
+			next[i] += jump
+			change += math.Abs(next[i] - rank[i])
+		}
+		rank, next = next, rank
+		if change < stationaryTolerance {
+			break
+		}
+	}
+
+	for i, key := range keys {
+		scores[key] = rank[i]
+	}
+	return scores
+}
+
+// sortByScore lists every song in the chain with its score, highest first.
+// Songs without a score have a score of 0.
+func (c Chain) sortByScore(scores map[string]float64) []Centrality {
+	if len(scores) == 0 {
+		return nil
+	}
+	distribution := make([]Centrality, len(c.popular))
+	for i, key := range c.popular {
+		distribution[i] = Centrality{
+			Artist: c.Songs[key].Artist,
+			Title:  c.Songs[key].Title,
+			Score:  scores[key],
+			Plays:  c.Plays[key],
+		}
+	}
+	// The keys are already sorted by plays, then key.
+	sort.SliceStable(distribution, func(i, j int) bool {
+		return distribution[i].Score > distribution[j].Score
+	})
+	return distribution
+}
+
+// SurpriseSong picks a song to start a playlist from at random, in proportion
+// to how central it is in the chain (see StationaryDistribution), out of the
+// songs that have something to follow them.
+// Returns an error if there aren't any.
+func (c Chain) SurpriseSong(r *rand.Rand) (lastFm.Song, error) {
+	distribution := c.StationaryDistribution()
+	var cdf CDF
+	total := 0.0
+	for i, song := range distribution {
+		if song.Score <= 0 || !c.isStart(c.key(lastFm.Song{Artist: song.Artist, Title: song.Title})) {
+			continue
+		}
+		total += song.Score
+		cdf = append(cdf, CDFPoint{Total: total, Index: i})
+	}
+	if len(cdf) == 0 {
+		return lastFm.Song{}, errors.New("There aren't any songs in your history to start a playlist from.")
+	}
+	song := distribution[searchCDF(cdf, r)]
+	return lastFm.Song{Artist: song.Artist, Title: song.Title}, nil
+}
@@ -0,0 +1,120 @@
+package markov
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// countingStore counts the prefixes fetched from a memoryStore.
+type countingStore struct {
+	memoryStore
+	fetches int
+}
+
+func (s *countingStore) Node(key string) (Suffixes, time.Time, bool, error) {
+	s.fetches++
+	return s.memoryStore.Node(key)
+}
+
+func TestStationaryDistributionSumsToOne(t *testing.T) {
+	chain := BuildChain(testHistory(), Settings{Order: 2})
+	distribution := chain.StationaryDistribution()
+	if len(distribution) != len(chain.Plays) {
+		t.Fatalf("got %d songs, want %d", len(distribution), len(chain.Plays))
+	}
+	total := 0.0
+	for i, song := range distribution {
+		total += song.Score
+		if i > 0 && song.Score > distribution[i-1].Score {
+			t.Errorf("%q comes after %q with a higher score", song.Title, distribution[i-1].Title)
+		}
+	}
+	if !closeTo(total, 1) {
+		t.Errorf("scores add up to %v, want 1", total)
+	}
+}
+
+// load creates the chain stored under header, fetching its prefixes from s.
+func (s *countingStore) load(t *testing.T, b []byte) Chain {
+	header := Header{}
+	err := json.Unmarshal(b, &header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := LoadChain(header, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+func TestStationaryDistributionLoaded(t *testing.T) {
+	history := testHistory()
+	built := BuildChain(history, Settings{Order: 2})
+	store := &countingStore{memoryStore: memoryStore{}}
+	loaded := store.load(t, store.save(t, built, allKeys(built)))
+	if got, want := loaded.StationaryDistribution(), built.StationaryDistribution(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if _, err := loaded.SurpriseSong(rand.New(rand.NewSource(1))); err != nil {
+		t.Error(err)
+	}
+	if store.fetches > 0 {
+		t.Errorf("fetched %d prefixes for the distribution", store.fetches)
+	}
+}
+
+func TestStationaryDistributionLoadedExtend(t *testing.T) {
+	history := testHistory()
+	first := BuildChain(history[:10], Settings{Order: 2})
+	store := &countingStore{memoryStore: memoryStore{}}
+	loaded := store.load(t, store.save(t, first, allKeys(first)))
+	loaded.Extend(history)
+	store.fetches = 0
+
+	// The songs added since it was built are last, without a score.
+	scores := first.Header().Central
+	distribution := loaded.StationaryDistribution()
+	if len(distribution) != len(loaded.Plays) {
+		t.Fatalf("got %d songs, want %d", len(distribution), len(loaded.Plays))
+	}
+	for i, song := range distribution {
+		key := loaded.key(lastFm.Song{Artist: song.Artist, Title: song.Title})
+		if song.Score != scores[key] {
+			t.Errorf("%q got a score of %v, want %v", song.Title, song.Score, scores[key])
+		}
+		if i < len(scores) && song.Score == 0 {
+			t.Errorf("%q has no score", song.Title)
+		}
+	}
+	if store.fetches > 0 {
+		t.Errorf("fetched %d prefixes for the distribution", store.fetches)
+	}
+}
+
+func TestStationaryDistributionExtend(t *testing.T) {
+	history := testHistory()
+	chain := BuildChain(history[:10], Settings{Order: 2})
+	scores := chain.Header().Central
+	chain.Extend(history)
+
+	// Extending the chain keeps the scores it was built with,
+	// rather than working them out again.
+	if got := chain.Header().Central; !reflect.DeepEqual(got, scores) {
+		t.Errorf("got scores %v, want %v", got, scores)
+	}
+	distribution := chain.StationaryDistribution()
+	if len(distribution) != len(chain.Plays) {
+		t.Fatalf("got %d songs, want %d", len(distribution), len(chain.Plays))
+	}
+	for _, song := range distribution[len(scores):] {
+		if song.Score != 0 {
+			t.Errorf("%q was added by extending the chain but has a score of %v", song.Title, song.Score)
+		}
+	}
+}
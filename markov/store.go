@@ -0,0 +1,182 @@
+package markov
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// NodeStore holds the prefixes of a chain outside of memory, so a chain
+// loaded from it (see LoadChain) only has to fetch the prefixes it uses.
+type NodeStore interface {
+	// Node fetches the suffixes of the prefix with the given key, along with
+	// the newest song in the chain when they were stored, which their weights
+	// are relative to. Returns false if the prefix isn't stored.
+	Node(key string) (Suffixes, time.Time, bool, error)
+}
+
+// Header is everything in a chain other than its prefixes, so a chain can
+// be stored in pieces and loaded without its whole history.
+type Header struct {
+	// Settings are what the chain was built with, without their Location
+	// since it can't be stored. Location is its name instead.
+	Settings   Settings
+	Location   string
+	Newest     time.Time
+	Tail       []lastFm.Song
+	Songs      map[string]lastFm.BaseSong
+	Plays      map[string]int
+	LastPlayed map[string]time.Time
+	// Starts are the keys of the single-song prefixes.
+	Starts []string
+	// Central is each song's score in the chain's stationary distribution,
+	// by key, since a loaded chain would have to fetch every prefix to work
+	// it out (see StationaryDistribution).
+	Central map[string]float64
+}
+
+// nodeCache holds the prefixes a chain has fetched from its store,
+// including the ones that weren't there.
+type nodeCache struct {
+	mu    sync.Mutex
+	nodes map[string]*Suffixes // nil if the prefix isn't stored
+}
+
+// Header returns everything in the chain other than its prefixes
+// (see NodeStore). The chain's stationary distribution is worked out for it
+// if it hasn't been yet.
+func (c Chain) Header() Header {
+	header := Header{
+		Settings:   c.settings,
+		Location:   c.settings.Location.String(),
+		Newest:     c.newest,
+		Tail:       c.tail,
+		Songs:      c.Songs,
+		Plays:      c.Plays,
+		LastPlayed: c.LastPlayed,
+		Starts:     make([]string, len(c.titles)),
+		Central:    c.centrality().scores,
+	}
+	header.Settings.Location = nil
+	for i, title := range c.titles {
+		header.Starts[i] = title.key
+	}
+	return header
+}
+
+// Newest is when the newest song in the chain was played.
+func (c Chain) Newest() time.Time {
+	return c.newest
+}
+
+// LoadChain creates a chain from its header, fetching its prefixes from
+// the store as they're used. Prefixes added by extending the chain are kept
+// in Prefixes, and have to be put in the store to be kept.
+// Returns an error if the header's location isn't valid.
+func LoadChain(header Header, store NodeStore) (Chain, error) {
+	loc, err := time.LoadLocation(header.Location)
+	if err != nil {
+		return Chain{}, err
+	}
+	settings := header.Settings
+	settings.Location = loc
+	settings = settings.withDefaults()
+	chain := Chain{
+		Order:      settings.Order,
+		Prefixes:   make(map[string]Suffixes),
+		Songs:      header.Songs,
+		Plays:      header.Plays,
+		LastPlayed: header.LastPlayed,
+		settings:   settings,
+		newest:     header.Newest,
+		tail:       header.Tail,
+		store:      store,
+		fetched:    &nodeCache{nodes: make(map[string]*Suffixes)},
+		stationary: &stationaryCache{scores: header.Central},
+	}
+	for key, song := range chain.Songs {
+		chain.indexSong(song, key)
+	}
+	chain.titles = make([]titleKey, len(header.Starts))
+	for i, key := range header.Starts {
+		chain.titles[i] = titleKey{title: titleOf(key), key: key}
+	}
+	sort.Slice(chain.titles, func(i, j int) bool {
+		return titleBefore(chain.titles[i], chain.titles[j])
+	})
+	chain.indexSongs()
+	return chain, nil
+}
+
+// suffixes finds the suffixes of the prefix with the given key, fetching
+// them from the store if the chain has one and doesn't have them yet.
+func (c Chain) suffixes(key string) (Suffixes, bool) {
+	if suffixes, exists := c.Prefixes[key]; exists {
+		return suffixes, true
+	}
+	if c.store == nil {
+		return Suffixes{}, false
+	}
+	c.fetched.mu.Lock()
+	defer c.fetched.mu.Unlock()
+	if suffixes, fetched := c.fetched.nodes[key]; fetched {
+		if suffixes == nil {
+			return Suffixes{}, false
+		}
+		return *suffixes, true
+	}
+	suffixes, at, exists, err := c.store.Node(key)
+	if err != nil {
+		log.Println("Couldn't fetch a prefix from the store:", err.Error())
+		return Suffixes{}, false
+	}
+	if !exists {
+		c.fetched.nodes[key] = nil
+		return Suffixes{}, false
+	}
+	// Weigh them the same as the rest of the chain.
+	suffixes = suffixes.scale(c.settings.decay(at, c.newest)).total()
+	c.fetched.nodes[key] = &suffixes
+	return suffixes, true
+}
+
+// own finds the suffixes of the prefix with the given key for the chain to
+// change, copying them if they came from the store.
+func (c Chain) own(key string) Suffixes {
+	if suffixes, exists := c.Prefixes[key]; exists {
+		return suffixes
+	}
+	suffixes, _ := c.suffixes(key)
+	suffixes.Suffixes = append([]Suffix(nil), suffixes.Suffixes...)
+	return suffixes
+}
+
+// forgetFetched drops the prefixes fetched from the store, so they're
+// fetched again and weighed as of the newest song in the chain.
+func (c *Chain) forgetFetched() {
+	if c.fetched == nil {
+		return
+	}
+	c.fetched.mu.Lock()
+	c.fetched.nodes = make(map[string]*Suffixes)
+	c.fetched.mu.Unlock()
+}
+
+// scale multiplies the weight of every suffix by factor,
+// keeping the ones above zero from going all the way to zero.
+func (s Suffixes) scale(factor float64) Suffixes {
+	if factor == 1 {
+		return s
+	}
+	for i := range s.Suffixes {
+		weight := s.Suffixes[i].Weight * factor
+		if weight > 0 && weight < minDecay {
+			weight = minDecay
+		}
+		s.Suffixes[i].Weight = weight
+	}
+	return s
+}
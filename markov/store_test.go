@@ -0,0 +1,108 @@
+package markov
+
+import (
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/snyderks/spotkov/lastFm"
+)
+
+// memoryStore keeps a chain's prefixes in a map, the way chainstore keeps
+// them in Redis.
+type memoryStore map[string]memoryNode
+
+type memoryNode struct {
+	suffixes []byte
+	at       time.Time
+}
+
+func (m memoryStore) Node(key string) (Suffixes, time.Time, bool, error) {
+	node, exists := m[key]
+	if !exists {
+		return Suffixes{}, time.Time{}, false, nil
+	}
+	suffixes := Suffixes{}
+	err := json.Unmarshal(node.suffixes, &suffixes.Suffixes)
+	return suffixes, node.at, true, err
+}
+
+// save puts the prefixes with the given keys in the store, and returns
+// the chain's header the way it would be stored.
+func (m memoryStore) save(t *testing.T, chain Chain, keys []string) []byte {
+	for _, key := range keys {
+		b, err := json.Marshal(chain.Prefixes[key].Suffixes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m[key] = memoryNode{suffixes: b, at: chain.Newest()}
+	}
+	header, err := json.Marshal(chain.Header())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+// load creates the chain stored in m under header.
+func (m memoryStore) load(t *testing.T, b []byte) Chain {
+	header := Header{}
+	err := json.Unmarshal(b, &header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := LoadChain(header, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chain
+}
+
+// allKeys is the keys of every prefix in memory.
+func allKeys(chain Chain) []string {
+	keys := make([]string, 0, len(chain.Prefixes))
+	for key := range chain.Prefixes {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestLoadChainMatchesBuildChain(t *testing.T) {
+	history := testHistory()
+	seed := lastFm.Song{Artist: "Grizzly Bear", Title: "Two Weeks"}
+	for _, settings := range []Settings{{Order: 2}, {Order: 2, HalfLife: 24 * time.Hour}} {
+		built := BuildChain(history, settings)
+		store := memoryStore{}
+		loaded := store.load(t, store.save(t, built, allKeys(built)))
+		for n := int64(0); n < 5; n++ {
+			want, _ := GenerateSongList(7, Constraints{}, seed, built, 0, rand.New(rand.NewSource(n)))
+			got, _ := GenerateSongList(7, Constraints{}, seed, loaded, 0, rand.New(rand.NewSource(n)))
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%+v seed %d: got %v, want %v", settings, n, titles(got), titles(want))
+			}
+		}
+	}
+}
+
+func TestLoadChainExtend(t *testing.T) {
+	history := testHistory()
+	for _, settings := range []Settings{{Order: 2}, {Order: 2, HalfLife: 24 * time.Hour}} {
+		built := BuildChain(history, settings)
+
+		store := memoryStore{}
+		first := BuildChain(history[:10], settings)
+		header := store.save(t, first, allKeys(first))
+		loaded := store.load(t, header)
+		changed := loaded.Extend(history)
+		loaded = store.load(t, store.save(t, loaded, changed))
+
+		for key, suffixes := range built.Prefixes {
+			stored, exists := loaded.suffixes(key)
+			if !exists || !sameSuffixes(suffixes, stored) {
+				t.Errorf("%+v: prefix %q got %+v, want %+v", settings, key, stored, suffixes)
+			}
+		}
+	}
+}
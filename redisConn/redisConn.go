@@ -0,0 +1,37 @@
+// Package redisConn connects to the Redis server in the config the same way
+// lastFm does, for the data Spotkov keeps in Redis itself.
+package redisConn
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/go-redis/redis"
+	"github.com/snyderks/spotkov/configRead"
+)
+
+// Connect creates a client for the Redis server at RedisURL in config.json,
+// or on localhost if there isn't a config.
+// Returns an error along with the client if the server can't be reached.
+func Connect() (*redis.Client, error) {
+	config, err := configRead.Read("config.json")
+	rURL := "localhost:6379"
+	if err == nil {
+		rURL = config.RedisURL
+	}
+	password := ""
+
+	// Need to construct a URL if it's not using localhost
+	if !strings.Contains(rURL, "localhost") {
+		parsedURL, _ := url.Parse(rURL)
+		password, _ = parsedURL.User.Password()
+		rURL = parsedURL.Host
+	}
+	c := redis.NewClient(&redis.Options{
+		Addr:     rURL,
+		Password: password,
+		DB:       0, // use default DB
+	})
+	_, err = c.Ping().Result()
+	return c, err
+}
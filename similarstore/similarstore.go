@@ -0,0 +1,189 @@
+// Package similarstore keeps the songs most like each song in a user's
+// history in Redis, as worked out by the sync command, so the web server can
+// look up one song's without reading the history.
+package similarstore
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/snyderks/spotkov-web/canonical"
+	"github.com/snyderks/spotkov-web/markov"
+	"github.com/snyderks/spotkov-web/redisConn"
+)
+
+// Redis key prefixes for each user's similar songs.
+const (
+	// similarPrefix starts the key of the hash of the songs most like each
+	// song, by its key (see markov.SimilarKey).
+	similarPrefix = "similar."
+	// titlesPrefix starts the key of the hash of the most played song with
+	// each title, by the title's key (see markov.SimilarTitleKey).
+	titlesPrefix = "similarTitles."
+	// infoPrefix starts the key holding how the similar songs were found.
+	// It changes when the way songs are keyed does, so they're found again.
+	infoPrefix = "similarInfo2."
+)
+
+// MaxSimilar is how many of the songs most like each song are kept.
+const MaxSimilar = 20
+
+// expiration is how long a user's similar songs are kept after they were
+// last found.
+const expiration = 30 * 24 * time.Hour
+
+// batchSize is how many songs are saved in one round trip.
+const batchSize = 1000
+
+// ErrNotStored is returned when there aren't any similar songs stored for a user.
+var ErrNotStored = errors.New("There aren't any similar songs stored.")
+
+// Info is how a user's similar songs were found.
+type Info struct {
+	Indexed    time.Time
+	SessionGap time.Duration
+	Canonical  canonical.Rules
+}
+
+var c *redis.Client
+
+// available is false if Redis couldn't be reached at startup.
+var available bool
+
+func init() {
+	var err error
+	c, err = redisConn.Connect()
+	available = err == nil
+	if err != nil {
+		log.Println("Similar songs won't be stored:", err.Error())
+	}
+}
+
+// Save stores the songs most like each song in a user's history, replacing
+// the ones stored before.
+func Save(userID string, info Info, index markov.SimilarityIndex) error {
+	if !available {
+		return errors.New("Redis isn't available.")
+	}
+	similar := make(map[string]interface{}, len(index.Similar))
+	for key, songs := range index.Similar {
+		b, err := json.Marshal(songs)
+		if err != nil {
+			return err
+		}
+		similar[key] = b
+	}
+	titles := make(map[string]interface{}, len(index.MostPlayed))
+	for title, key := range index.MostPlayed {
+		titles[title] = key
+	}
+	err := replace(similarPrefix+userID, similar)
+	if err != nil {
+		return err
+	}
+	err = replace(titlesPrefix+userID, titles)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	// The info goes last so it's only there once the songs are.
+	return c.Set(infoPrefix+userID, b, expiration).Err()
+}
+
+// replace sets the fields of the hash under key, removing any others, so
+// the hash can be read the whole time it's being replaced.
+func replace(key string, fields map[string]interface{}) error {
+	old, err := c.HKeys(key).Result()
+	if err != nil {
+		return err
+	}
+	batch := make(map[string]interface{}, batchSize)
+	for field, value := range fields {
+		batch[field] = value
+		if len(batch) == batchSize {
+			err = c.HMSet(key, batch).Err()
+			if err != nil {
+				return err
+			}
+			batch = make(map[string]interface{}, batchSize)
+		}
+	}
+	if len(batch) > 0 {
+		err = c.HMSet(key, batch).Err()
+		if err != nil {
+			return err
+		}
+	}
+	var stale []string
+	for _, field := range old {
+		if _, exists := fields[field]; !exists {
+			stale = append(stale, field)
+		}
+	}
+	for start := 0; start < len(stale); start += batchSize {
+		end := start + batchSize
+		if end > len(stale) {
+			end = len(stale)
+		}
+		err = c.HDel(key, stale[start:end]...).Err()
+		if err != nil {
+			return err
+		}
+	}
+	return c.Expire(key, expiration).Err()
+}
+
+// ReadInfo reads how a user's similar songs were found.
+// Returns ErrNotStored if there aren't any.
+func ReadInfo(userID string) (Info, error) {
+	if !available {
+		return Info{}, ErrNotStored
+	}
+	b, err := c.Get(infoPrefix + userID).Bytes()
+	if err == redis.Nil {
+		return Info{}, ErrNotStored
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{}
+	err = json.Unmarshal(b, &info)
+	return info, err
+}
+
+// Similar reads the songs most like the one with the key in a user's
+// history, most alike first. Returns false if the song isn't stored.
+func Similar(userID string, key string) ([]markov.SimilarSong, bool, error) {
+	b, err := c.HGet(similarPrefix+userID, key).Bytes()
+	if err == redis.Nil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var songs []markov.SimilarSong
+	err = json.Unmarshal(b, &songs)
+	if err != nil {
+		return nil, false, err
+	}
+	return songs, true, nil
+}
+
+// MostPlayed reads the key of the most played song with the title with the
+// key in a user's history. Returns false if there isn't one.
+func MostPlayed(userID string, titleKey string) (string, bool, error) {
+	key, err := c.HGet(titlesPrefix+userID, titleKey).Result()
+	if err == redis.Nil {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return key, true, nil
+}
@@ -0,0 +1,325 @@
+// Package syncqueue passes work that needs a user's whole listening history
+// from the web server to the sync command through Redis, so the web server
+// never has to read a whole history itself.
+// Work is queued by user, so each user's history is read once for all of
+// the work waiting on it.
+package syncqueue
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/snyderks/spotkov-web/markov"
+	"github.com/snyderks/spotkov-web/redisConn"
+)
+
+// Kinds of jobs.
+const (
+	// BuildChain builds a chain with the job's settings from the whole
+	// history and stores it under the job's ID (see chainstore).
+	BuildChain = "chain"
+	// IndexSimilar trains song embeddings with the job's settings from the
+	// whole history, finds the songs most like each song with them, and
+	// stores those for the user (see similarstore).
+	IndexSimilar = "similar"
+	// Refresh adds the songs played since each of the user's stored chains
+	// was built to them.
+	Refresh = "refresh"
+)
+
+// Redis keys for the queue.
+const (
+	// usersKey is a list of the users waiting to be synced, first come first.
+	usersKey = "sync.users"
+	// jobsPrefix starts the key of the set of jobs waiting for each user.
+	jobsPrefix = "sync.jobs."
+	// queuedPrefix starts the key that marks a user as in usersKey
+	// or being synced.
+	queuedPrefix = "sync.queued."
+	// failedPrefix starts the key holding why a job failed.
+	failedPrefix = "sync.failed."
+	// wantedPrefix starts the key that marks a job as still wanted.
+	wantedPrefix = "sync.wanted."
+	// addedPrefix starts the key that marks a job as recently added
+	// (see AddEvery).
+	addedPrefix = "sync.added."
+)
+
+// maxPendingJobs is the most jobs waiting for a user at once, so what
+// clients ask for can't keep the sync command busy with one user.
+const maxPendingJobs = 4
+
+// queuedExpiration is how long a user is marked as queued or being synced.
+// If the sync command stops partway through a user, they're queued again
+// after that the next time anything is added for them.
+const queuedExpiration = time.Hour
+
+// failedExpiration is how long why a job failed is kept for the web server
+// to pass on.
+const failedExpiration = 10 * time.Minute
+
+// wantedExpiration is how long a job is wanted after it was last added.
+// Jobs that haven't been asked for since are dropped rather than done.
+const wantedExpiration = 30 * time.Minute
+
+// ErrTooManyJobs is returned by Add when a user already has as many jobs
+// waiting as they can.
+var ErrTooManyJobs = errors.New("Spotkov is already building as much from your listening history as it can at once. Please try again in a minute.")
+
+// nextTimeout is how long Next waits for a user before checking again.
+const nextTimeout = time.Minute
+
+// Job is work for the sync command to do from a user's whole history.
+type Job struct {
+	Kind   string
+	UserID string
+	// ID is what the job's result is stored under, such as the chain's id.
+	// Only one job of a kind with the same ID is queued at once.
+	ID       string
+	Settings markov.Settings
+}
+
+// storedJob is how a job is kept in Redis. Settings can't be stored with
+// their Location, so Location is its name instead.
+type storedJob struct {
+	Kind     string
+	UserID   string
+	ID       string
+	Settings markov.Settings
+	Location string
+}
+
+var c *redis.Client
+
+// available is false if Redis couldn't be reached at startup.
+var available bool
+
+func init() {
+	var err error
+	c, err = redisConn.Connect()
+	available = err == nil
+	if err != nil {
+		log.Println("Nothing can be synced:", err.Error())
+	}
+}
+
+// Add queues a job for the sync command, and the user to be synced if they
+// aren't already. A job that's already queued isn't added again, but is
+// wanted for longer (see Forget).
+// Returns ErrTooManyJobs if the user already has maxPendingJobs waiting.
+func Add(job Job) error {
+	if !available {
+		return errors.New("Redis isn't available.")
+	}
+	b, err := encode(job)
+	if err != nil {
+		return err
+	}
+	var added, waiting *redis.IntCmd
+	_, err = c.Pipelined(func(pipe redis.Pipeliner) error {
+		added = pipe.SAdd(jobsPrefix+job.UserID, b)
+		waiting = pipe.SCard(jobsPrefix + job.UserID)
+		pipe.Set(wantedKey(job), 1, wantedExpiration)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if added.Val() == 1 && waiting.Val() > maxPendingJobs {
+		c.SRem(jobsPrefix+job.UserID, b)
+		return ErrTooManyJobs
+	}
+	return queueUser(job.UserID)
+}
+
+// AddEvery adds a job, unless the same one was added by AddEvery within
+// the interval, so jobs asked for on every request aren't done as often.
+func AddEvery(job Job, interval time.Duration) error {
+	if !available {
+		return errors.New("Redis isn't available.")
+	}
+	due, err := c.SetNX(addedPrefix+job.Kind+"."+job.ID, 1, interval).Result()
+	if err != nil || !due {
+		return err
+	}
+	return Add(job)
+}
+
+// Forget drops a job from the queue if it hasn't been started yet,
+// since nothing is waiting on it anymore.
+func Forget(job Job) error {
+	if !available {
+		return nil
+	}
+	return c.Del(wantedKey(job)).Err()
+}
+
+// queueUser puts the user at the end of the queue, unless they're already
+// queued or being synced.
+func queueUser(userID string) error {
+	added, err := c.SetNX(queuedPrefix+userID, 1, queuedExpiration).Result()
+	if err != nil || !added {
+		return err
+	}
+	return c.LPush(usersKey, userID).Err()
+}
+
+// Next waits for a user to sync, and returns them along with the jobs waiting
+// on their history. Jobs that aren't wanted anymore are dropped, and users
+// without any others are skipped. Finish has to be called once they're done.
+func Next() (string, []Job, error) {
+	if !available {
+		return "", nil, errors.New("Redis isn't available.")
+	}
+	for {
+		popped, err := c.BRPop(nextTimeout, usersKey).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		userID := popped[1]
+		jobs, err := wanted(userID)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(jobs) == 0 {
+			Finish(userID)
+			continue
+		}
+		return userID, jobs, nil
+	}
+}
+
+// wanted reads the jobs waiting for a user, taking the ones that can't be
+// read or aren't wanted anymore off the queue.
+func wanted(userID string) ([]Job, error) {
+	members, err := c.SMembers(jobsPrefix + userID).Result()
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]Job, 0, len(members))
+	for _, member := range members {
+		job, err := decode(member)
+		if err != nil {
+			log.Println("Couldn't read a queued job:", err.Error())
+			c.SRem(jobsPrefix+userID, member)
+			continue
+		}
+		exists, err := c.Exists(wantedKey(job)).Result()
+		if err != nil {
+			return nil, err
+		}
+		if exists == 0 {
+			c.SRem(jobsPrefix+userID, member)
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// Done takes a job off the queue once it's been done, keeping err for a while
+// if it failed so the web server can pass it on (see Failed).
+func Done(job Job, err error) {
+	b, encodeErr := encode(job)
+	if encodeErr != nil {
+		log.Println("Couldn't take a job off the queue:", encodeErr.Error())
+		return
+	}
+	_, pipeErr := c.Pipelined(func(pipe redis.Pipeliner) error {
+		pipe.SRem(jobsPrefix+job.UserID, b)
+		if err != nil {
+			pipe.Set(failedKey(job), err.Error(), failedExpiration)
+		}
+		return nil
+	})
+	if pipeErr != nil {
+		log.Println("Couldn't take a job off the queue:", pipeErr.Error())
+	}
+}
+
+// Finish marks a user's sync as finished, queueing them again if more jobs
+// were added for them in the meantime.
+func Finish(userID string) {
+	err := c.Del(queuedPrefix + userID).Err()
+	if err != nil {
+		log.Println("Couldn't finish syncing "+userID+":", err.Error())
+		return
+	}
+	waiting, err := c.SCard(jobsPrefix + userID).Result()
+	if err == nil && waiting > 0 {
+		err = queueUser(userID)
+	}
+	if err != nil {
+		log.Println("Couldn't queue "+userID+" again:", err.Error())
+	}
+}
+
+// Failed returns why the last job of the same kind and ID failed, if it did
+// recently, and forgets it so it's only passed on once.
+func Failed(job Job) error {
+	if !available {
+		return nil
+	}
+	var get *redis.StringCmd
+	c.Pipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(failedKey(job))
+		pipe.Del(failedKey(job))
+		return nil
+	})
+	message, err := get.Result()
+	if err != nil {
+		return nil
+	}
+	return errors.New(message)
+}
+
+// failedKey is the Redis key holding why the job failed.
+func failedKey(job Job) string {
+	return failedPrefix + job.Kind + "." + job.ID
+}
+
+// wantedKey is the Redis key marking the job as still wanted.
+func wantedKey(job Job) string {
+	return wantedPrefix + job.Kind + "." + job.ID
+}
+
+// encode turns a job into the way it's kept in Redis.
+func encode(job Job) (string, error) {
+	stored := storedJob{
+		Kind:     job.Kind,
+		UserID:   job.UserID,
+		ID:       job.ID,
+		Settings: job.Settings,
+	}
+	if job.Settings.Location != nil {
+		stored.Location = job.Settings.Location.String()
+	}
+	stored.Settings.Location = nil
+	b, err := json.Marshal(stored)
+	return string(b), err
+}
+
+// decode reads a job the way it's kept in Redis.
+func decode(s string) (Job, error) {
+	stored := storedJob{}
+	err := json.Unmarshal([]byte(s), &stored)
+	if err != nil {
+		return Job{}, err
+	}
+	job := Job{
+		Kind:     stored.Kind,
+		UserID:   stored.UserID,
+		ID:       stored.ID,
+		Settings: stored.Settings,
+	}
+	if len(stored.Location) > 0 {
+		job.Settings.Location, err = time.LoadLocation(stored.Location)
+	}
+	return job, err
+}